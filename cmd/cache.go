@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// byteUnits are the size suffixes accepted by parseByteSize, largest first so "MB" is matched before "B".
+var byteUnits = []struct { //nolint:gochecknoglobals // read only lookup
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func NewCacheCommand() *cobra.Command {
+	CacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the local cache of fetched sources",
+		Long: `Cache manages the on-disk cache that fetched sources are kept in, so that projects can be
+built again without fetching sources whose content hasn't changed, or built with --offline.
+The cache lives in the directory given by --cache-dir.`,
+		Run: func(_ *cobra.Command, _ []string) {},
+	}
+
+	CacheCmd.AddCommand(
+		NewCacheListCommand(),
+		NewCachePruneCommand(),
+		NewCacheWarmCommand(),
+	)
+	return CacheCmd
+}
+
+// openSourceCache opens the source cache at the configured cache directory.
+func openSourceCache() (*storage.SourceCache, error) {
+	cacheDir, err := storage.ExpandPath(globalCfg.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve cache directory: %w", err)
+	}
+
+	return storage.NewSourceCache(afero.NewOsFs(), cacheDir), nil
+}
+
+// formatByteSize renders n bytes with the largest unit that keeps it at or above 1.
+func formatByteSize(n int64) string {
+	for _, u := range byteUnits {
+		if n >= u.size && u.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// parseByteSize parses sizes such as 512KB, 1.5GB or 2048, which is taken as bytes.
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n >= 0) || math.IsInf(n, 1) {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional B, KB, MB or GB suffix", size)
+	}
+
+	bytes := n * float64(unit)
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q, it is too large", size)
+	}
+
+	return int64(bytes), nil
+}
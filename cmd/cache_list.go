@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCacheListCommand() *cobra.Command {
+	CacheListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists the sources held in the source cache",
+		Long: `List shows every source held in the source cache with its alias, URL, path, the ref as
+written on the source, the revision it resolved to, its size and when it was fetched. The most recently
+fetched entries are listed first.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cache, err := openSourceCache()
+			if err != nil {
+				return err
+			}
+
+			entries, err := cache.List()
+			if err != nil {
+				return fmt.Errorf("failed to read source cache: %w", err)
+			}
+
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].FetchedAt.After(entries[j].FetchedAt)
+			})
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0) //nolint:mnd
+			_, _ = fmt.Fprintln(w, "ALIAS\tURL\tPATH\tREF\tRESOLVED\tSIZE\tFETCHED")
+			for _, e := range entries {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					e.Alias,
+					e.URL,
+					e.Path,
+					e.Ref,
+					e.ResolvedRef,
+					formatByteSize(e.Size),
+					e.FetchedAt.Local().Format(time.RFC3339),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	return CacheListCmd
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCachePruneCommand() *cobra.Command {
+	var (
+		olderThan time.Duration
+		maxSize   string
+	)
+
+	CachePruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Removes sources from the source cache by age or size budget",
+		Long: `Prune removes cached sources fetched longer ago than --older-than, then removes the oldest
+remaining sources until the cache fits within --max-size. Pruned sources are fetched again the next
+time they are used.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var budget int64
+			if maxSize != "" {
+				var err error
+				budget, err = parseByteSize(maxSize)
+				if err != nil {
+					return err
+				}
+			}
+
+			cache, err := openSourceCache()
+			if err != nil {
+				return err
+			}
+
+			removed, err := cache.Prune(olderThan, budget)
+			for _, e := range removed {
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "removed %s %s@%s (%s)\n",
+					e.Alias, e.URL, e.ResolvedRef, formatByteSize(e.Size))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to prune source cache: %w", err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "pruned %d cached sources\n", len(removed))
+			return nil
+		},
+	}
+
+	CachePruneCmd.Flags().DurationVar(
+		&olderThan, "older-than", 0, "remove sources fetched longer ago than this, e.g. 720h",
+	)
+	CachePruneCmd.Flags().StringVar(
+		&maxSize, "max-size", "", "remove the oldest sources until the cache fits this size, e.g. 500MB",
+	)
+
+	CachePruneCmd.MarkFlagsOneRequired("older-than", "max-size")
+
+	return CachePruneCmd
+}
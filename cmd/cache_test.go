@@ -0,0 +1,373 @@
+//go:build !integration
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OneFineDev/tmpltr/internal/services"
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useCacheDir points the global config at an empty cache directory for the rest of the test.
+func useCacheDir(t *testing.T) string {
+	t.Helper()
+	cfg, log := globalCfg, appLogger
+	t.Cleanup(func() { globalCfg, appLogger = cfg, log })
+
+	globalCfg = &GlobalConfig{CacheDir: t.TempDir()}
+	appLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return globalCfg.CacheDir
+}
+
+// seedCache stores a source of size bytes in the cache at dir as if it was fetched age ago.
+func seedCache(t *testing.T, dir, alias string, size int, age time.Duration) storage.CacheEntry {
+	t.Helper()
+	mfs := memfs.New()
+	require.NoError(t, util.WriteFile(mfs, "README.md", bytes.Repeat([]byte("x"), size), 0644))
+
+	entry, err := storage.NewSourceCache(afero.NewOsFs(), dir).Put(storage.CacheEntry{
+		Alias:       alias,
+		SourceType:  types.GitSourceType,
+		URL:         "https://example.com/" + alias + ".git",
+		Ref:         "main",
+		Path:        "/",
+		ResolvedRef: "rev-" + alias,
+	}, mfs)
+	require.NoError(t, err)
+
+	entry.FetchedAt = time.Now().Add(-age).UTC()
+	b, err := json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, entry.Key, "entry.json"), b, 0600))
+	return entry
+}
+
+// cachedAliases returns the aliases of the entries in the cache at dir, sorted.
+func cachedAliases(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := storage.NewSourceCache(afero.NewOsFs(), dir).List()
+	require.NoError(t, err)
+
+	aliases := make([]string, 0, len(entries))
+	for _, e := range entries {
+		aliases = append(aliases, e.Alias)
+	}
+	slices.Sort(aliases)
+	return aliases
+}
+
+// executeCommand runs cmd with args and returns what it wrote to its output.
+func executeCommand(t *testing.T, cmd *cobra.Command, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+	err := cmd.ExecuteContext(t.Context())
+	return out.String(), err
+}
+
+func TestParseByteSize(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name        string
+		size        string
+		expected    int64
+		expectedErr bool
+	}{
+		{name: "Bare number is bytes", size: "2048", expected: 2048},
+		{name: "Bytes", size: "512B", expected: 512},
+		{name: "Kilobytes", size: "512KB", expected: 512 << 10},
+		{name: "Megabytes", size: "10MB", expected: 10 << 20},
+		{name: "Gigabytes", size: "2GB", expected: 2 << 30},
+		{name: "Fractional size", size: "1.5GB", expected: 3 << 29},
+		{name: "Lower case suffix and spaces", size: " 64 mb ", expected: 64 << 20},
+		{name: "Zero", size: "0", expected: 0},
+		{name: "Empty", size: "", expectedErr: true},
+		{name: "Unit without a number", size: "MB", expectedErr: true},
+		{name: "Unknown unit", size: "5TB", expectedErr: true},
+		{name: "Negative", size: "-1KB", expectedErr: true},
+		{name: "Not a number", size: "NaN", expectedErr: true},
+		{name: "Infinite", size: "InfGB", expectedErr: true},
+		{name: "Overflows int64", size: "9223372036854775807KB", expectedErr: true},
+		{name: "Overflows int64 by exponent", size: "1e30", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			n, err := parseByteSize(tt.size)
+
+			// Assert
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, n)
+		})
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name     string
+		n        int64
+		expected string
+	}{
+		{name: "Zero", n: 0, expected: "0B"},
+		{name: "Bytes", n: 1023, expected: "1023B"},
+		{name: "Kilobytes", n: 1 << 10, expected: "1.0KB"},
+		{name: "Fractional megabytes", n: 3 << 19, expected: "1.5MB"},
+		{name: "Gigabytes", n: 5 << 30, expected: "5.0GB"},
+		{name: "Larger than the largest unit", n: 2048 << 30, expected: "2048.0GB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			s := formatByteSize(tt.n)
+
+			// Assert
+			assert.Equal(t, tt.expected, s)
+		})
+	}
+}
+
+func TestCacheListCommand(t *testing.T) {
+	tests := []struct {
+		name            string
+		seed            []string
+		expectedAliases []string
+	}{
+		{
+			name: "lists only the header for an empty cache",
+		},
+		{
+			name:            "lists the most recently fetched entries first",
+			seed:            []string{"common", "goWeb", "vscode"},
+			expectedAliases: []string{"vscode", "goWeb", "common"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			dir := useCacheDir(t)
+			entries := make(map[string]storage.CacheEntry)
+			for i, alias := range tt.seed {
+				entries[alias] = seedCache(t, dir, alias, 2048, time.Duration(len(tt.seed)-i)*time.Hour)
+			}
+
+			// Act
+			out, err := executeCommand(t, NewCacheListCommand())
+
+			// Assert
+			require.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			require.Len(t, lines, len(tt.expectedAliases)+1)
+			assert.Equal(t, []string{"ALIAS", "URL", "PATH", "REF", "RESOLVED", "SIZE", "FETCHED"}, strings.Fields(lines[0]))
+			for i, alias := range tt.expectedAliases {
+				e := entries[alias]
+				assert.Equal(t, []string{
+					alias, e.URL, "/", "main", "rev-" + alias, "2.0KB", e.FetchedAt.Local().Format(time.RFC3339),
+				}, strings.Fields(lines[i+1]))
+			}
+		})
+	}
+}
+
+func TestCachePruneCommand(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		expectedOut      string
+		expectedRemained []string
+		expectedErr      bool
+	}{
+		{
+			name:             "removes sources older than --older-than",
+			args:             []string{"--older-than", "24h"},
+			expectedOut:      "removed common https://example.com/common.git@rev-common (1000B)\npruned 1 cached sources\n",
+			expectedRemained: []string{"goWeb", "vscode"},
+		},
+		{
+			name:             "removes the oldest sources until the cache fits --max-size",
+			args:             []string{"--max-size", "2KB"},
+			expectedOut:      "removed common https://example.com/common.git@rev-common (1000B)\npruned 1 cached sources\n",
+			expectedRemained: []string{"goWeb", "vscode"},
+		},
+		{
+			name: "applies both limits",
+			args: []string{"--older-than", "24h", "--max-size", "1000B"},
+			expectedOut: "removed common https://example.com/common.git@rev-common (1000B)\n" +
+				"removed goWeb https://example.com/goWeb.git@rev-goWeb (1000B)\npruned 2 cached sources\n",
+			expectedRemained: []string{"vscode"},
+		},
+		{
+			name:             "removes nothing when every source is within the limits",
+			args:             []string{"--older-than", "72h", "--max-size", "1MB"},
+			expectedOut:      "pruned 0 cached sources\n",
+			expectedRemained: []string{"common", "goWeb", "vscode"},
+		},
+		{
+			name:             "requires a limit",
+			expectedErr:      true,
+			expectedRemained: []string{"common", "goWeb", "vscode"},
+		},
+		{
+			name:             "rejects a --max-size that isn't a size",
+			args:             []string{"--max-size", "lots"},
+			expectedErr:      true,
+			expectedRemained: []string{"common", "goWeb", "vscode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			dir := useCacheDir(t)
+			seedCache(t, dir, "common", 1000, 48*time.Hour)
+			seedCache(t, dir, "goWeb", 1000, 2*time.Hour)
+			seedCache(t, dir, "vscode", 1000, time.Hour)
+
+			// Act
+			out, err := executeCommand(t, NewCachePruneCommand(), tt.args...)
+
+			// Assert
+			assert.Equal(t, tt.expectedRemained, cachedAliases(t, dir))
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOut, out)
+		})
+	}
+}
+
+// fakeCloner serves every source with an empty tree at the same revision, counting its clones.
+type fakeCloner struct {
+	clones *int
+}
+
+func (f *fakeCloner) Clone(_ context.Context) (billy.Filesystem, error) {
+	*f.clones++
+	return memfs.New(), nil
+}
+
+func (f *fakeCloner) SetSource(_ *types.Source) {}
+
+func (f *fakeCloner) Resolve(_ context.Context) (string, error) {
+	return "rev-1", nil
+}
+
+const warmSourceConfig = `
+sourceSets:
+  - alias: goWebSet
+    sources:
+      - common
+      - goWeb
+sources:
+  - alias: common
+    sourceType: git
+    url: "https://example.com/common.git"
+    path: "/"
+  - alias: goWeb
+    sourceType: git
+    url: "https://example.com/goWeb.git"
+    path: "/"
+`
+
+func TestCacheWarmCommand(t *testing.T) {
+	// Arrange
+	dir := useCacheDir(t)
+	globalCfg.SourceConfigFile = filepath.Join(t.TempDir(), "sources.yaml")
+	require.NoError(t, os.WriteFile(globalCfg.SourceConfigFile, []byte(warmSourceConfig), 0600))
+
+	clones := 0
+	t.Cleanup(func() { newSourceService = services.NewSourceService })
+	newSourceService = func(cfg *services.SourcesCommandConfig, logger *slog.Logger, cmdName string) *services.SourceService {
+		ss := services.NewSourceService(cfg, logger, cmdName)
+		ss.NewClient = func(_ types.SourceType) (types.SourceCloner, error) {
+			return &fakeCloner{clones: &clones}, nil
+		}
+		return ss
+	}
+
+	// The steps share the cache and run in order.
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOut    string
+		expectedClones int
+		expectedErr    bool
+	}{
+		{
+			name:           "fetches every source of the set into the cache",
+			args:           []string{"--source-set", "goWebSet"},
+			expectedOut:    "fetched 2 sources for goWebSet\n",
+			expectedClones: 2,
+		},
+		{
+			name:           "serves sources cached at their current revision",
+			args:           []string{"--source-set", "goWebSet"},
+			expectedOut:    "fetched 2 sources for goWebSet\n",
+			expectedClones: 2,
+		},
+		{
+			name:           "refresh fetches cached sources again",
+			args:           []string{"--source-set", "goWebSet", "--refresh"},
+			expectedOut:    "fetched 2 sources for goWebSet\n",
+			expectedClones: 4,
+		},
+		{
+			name:           "fails for an unknown source set",
+			args:           []string{"--source-set", "missingSet"},
+			expectedClones: 4,
+			expectedErr:    true,
+		},
+		{
+			name:           "requires a source set",
+			expectedClones: 4,
+			expectedErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			out, err := executeCommand(t, NewCacheWarmCommand(), tt.args...)
+
+			// Assert
+			assert.Equal(t, tt.expectedClones, clones)
+			assert.Equal(t, []string{"common", "goWeb"}, cachedAliases(t, dir))
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOut, out)
+		})
+	}
+}
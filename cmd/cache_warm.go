@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/OneFineDev/tmpltr/internal/services"
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	package_errors "github.com/OneFineDev/tmpltr/internal/tmpltrerrors"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func NewCacheWarmCommand() *cobra.Command {
+	warmCmdCfg := &services.SourcesCommandConfig{}
+
+	CacheWarmCmd := &cobra.Command{
+		Use:   "warm",
+		Short: "Fetches every source in a SourceSet into the source cache",
+		Long: `Warm fetches every source in the specified SourceSet into the source cache, so the set can
+later be built with --offline. Sources already cached at their current revision are not fetched
+again unless --refresh is passed.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+
+			var err error
+			warmCmdCfg.CacheDir, err = storage.ExpandPath(globalCfg.CacheDir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve cache directory: %w", err)
+			}
+			warmCmdCfg.Concurrency = globalCfg.Concurrency
+			warmCmdCfg.SourceTimeout = globalCfg.SourceTimeout
+			warmCmdCfg.Retries = globalCfg.Retries
+			warmCmdCfg.CatalogRef = globalCfg.CatalogRef
+			warmCmdCfg.TrustCatalogSecrets = globalCfg.TrustCatalogSecrets
+			warmCmdCfg.CatalogAuths = catalogAuths()
+
+			ss := newSourceService(warmCmdCfg, appLogger, cmd.Name())
+
+			parsedSourcesConfig, err := ss.LoadSourceConfig(ctx, afero.NewOsFs(), sourceConfigLocations())
+			if err != nil {
+				return fmt.Errorf(package_errors.ParseSourceConfigFileError, err)
+			}
+
+			err = ss.BuildProjectSourceConfigs(parsedSourcesConfig)
+			if err != nil {
+				return fmt.Errorf(package_errors.BuildSourceConfigError, err)
+			}
+
+			billyChan, errChan := ss.CloneSources(ctx)
+
+			var receivedErrors []error
+			fetched := 0
+			for billyChan != nil || errChan != nil {
+				select {
+				case _, ok := <-billyChan:
+					if !ok {
+						billyChan = nil
+						continue
+					}
+					fetched++
+				case e, ok := <-errChan:
+					if !ok {
+						errChan = nil
+						continue
+					}
+					receivedErrors = append(receivedErrors, e)
+				}
+			}
+
+			if len(receivedErrors) > 0 {
+				return package_errors.FlattenCloneErrors(receivedErrors...)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "fetched %d sources for %s\n", fetched, warmCmdCfg.SourceSet)
+			return nil
+		},
+	}
+
+	CacheWarmCmd.Flags().StringVar(
+		&warmCmdCfg.SourceSet, "source-set", "", "the source set (defined in the sources config file) to fetch",
+	)
+	CacheWarmCmd.Flags().BoolVar(
+		&warmCmdCfg.Refresh, "refresh", false, "fetch every source even when the source cache is current",
+	)
+
+	_ = CacheWarmCmd.MarkFlagRequired("source-set")
+
+	return CacheWarmCmd
+}
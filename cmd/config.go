@@ -1,9 +1,90 @@
 package cmd
 
+import (
+	"strings"
+	"time"
+
+	"github.com/OneFineDev/tmpltr/internal/services"
+	"github.com/OneFineDev/tmpltr/internal/types"
+)
+
 type GlobalConfig struct {
 	LoggingConfig
 	Verbose          bool
 	SourceConfigFile string
+	CacheDir         string
+	Concurrency      int
+	SourceTimeout    time.Duration
+	Retries          int
+
+	// SourceConfigFiles are merged in order, after SourceConfigFile when it's set
+	SourceConfigFiles []string
+
+	// Catalogs are source config files in git repositories, merged ahead of SourceConfigFile
+	Catalogs []Catalog
+
+	// CatalogRef is the ref every catalog is read at, in place of its own
+	CatalogRef string
+
+	// TrustCatalogSecrets lets auths from catalogs use secret references and the env PAT overrides
+	TrustCatalogSecrets bool
+}
+
+/*
+Catalog is a source config file at Path in the git repository at URL, read at Ref or the remote HEAD.
+The rest of its fields authenticate the repository like those of a source auth, see CatalogAuth.
+*/
+type Catalog struct {
+	URL  string
+	Path string
+	Ref  string
+
+	UserName         string
+	Pat              string
+	SSHKeyPath       string
+	SSHKeyPassphrase string
+	CredentialHelper string
+	KnownHostsPath   string
+}
+
+// CatalogAuth returns how the catalog's repository is authenticated, or false when nothing is set.
+func (c Catalog) CatalogAuth() (services.CatalogAuth, bool) {
+	auth := services.CatalogAuth{
+		SourceAuth: types.SourceAuth{
+			UserName:         c.UserName,
+			Pat:              c.Pat,
+			SSHKey:           c.SSHKeyPath,
+			SSHKeyPassphrase: c.SSHKeyPassphrase,
+			CredentialHelper: types.CredentialHelper(c.CredentialHelper),
+		},
+		KnownHostsPath: c.KnownHostsPath,
+	}
+	if auth == (services.CatalogAuth{}) {
+		return auth, false
+	}
+
+	auth.AuthAlias = c.URL
+	return auth, true
+}
+
+// catalogAuths returns how the catalogs listed in the config are authenticated, keyed by their URL.
+func catalogAuths() map[string]services.CatalogAuth {
+	auths := make(map[string]services.CatalogAuth)
+	for _, catalog := range globalCfg.Catalogs {
+		if auth, ok := catalog.CatalogAuth(); ok {
+			auths[catalog.URL] = auth
+		}
+	}
+	return auths
+}
+
+// Location returns the catalog as a source config file location, <url>//<path>[?ref=<ref>].
+func (c Catalog) Location() string {
+	location := c.URL + "//" + strings.TrimPrefix(c.Path, "/")
+	if c.Ref != "" {
+		location += "?ref=" + c.Ref
+	}
+	return location
 }
 
 type LoggingConfig struct {
@@ -1,10 +1,12 @@
 package cmd
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/OneFineDev/tmpltr/internal/services"
@@ -27,21 +29,27 @@ values which need to be provided, these can be provided interactively or by
 passing a values file to the command on the --values-file flag. See 'get values'
 command documentation for an easy way to produce values files.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			ctx := context.Background()
+			ctx := cmd.Context()
 
-			sourceConfigFile, err := os.Open(globalCfg.SourceConfigFile)
+			var err error
+			sourceCmdCfg.CacheDir, err = storage.ExpandPath(globalCfg.CacheDir)
 			if err != nil {
-				return fmt.Errorf(package_errors.OpenSourceConfigFileError, err)
+				return fmt.Errorf("failed to resolve cache directory: %w", err)
 			}
-			defer sourceConfigFile.Close()
+			sourceCmdCfg.Concurrency = globalCfg.Concurrency
+			sourceCmdCfg.SourceTimeout = globalCfg.SourceTimeout
+			sourceCmdCfg.Retries = globalCfg.Retries
+			sourceCmdCfg.CatalogRef = globalCfg.CatalogRef
+			sourceCmdCfg.TrustCatalogSecrets = globalCfg.TrustCatalogSecrets
+			sourceCmdCfg.CatalogAuths = catalogAuths()
 
-			parsedSourcesConfig, err := services.ParseSourceConfigFile(sourceConfigFile)
+			ss := newSourceService(sourceCmdCfg, appLogger, cmd.Name())
+
+			parsedSourcesConfig, err := ss.LoadSourceConfig(ctx, afero.NewOsFs(), sourceConfigLocations())
 			if err != nil {
 				return fmt.Errorf(package_errors.ParseSourceConfigFileError, err)
 			}
 
-			ss := services.NewSourceService(sourceCmdCfg, appLogger, cmd.Name())
-
 			err = ss.BuildProjectSourceConfigs(parsedSourcesConfig)
 			if err != nil {
 				return fmt.Errorf(package_errors.BuildSourceConfigError, err)
@@ -65,8 +73,24 @@ command documentation for an easy way to produce values files.`,
 				}
 			}
 
+			conflictPolicy, err := ss.ConflictPolicy()
+			if err != nil {
+				return fmt.Errorf(package_errors.BuildSourceConfigError, err)
+			}
+
+			targetValues, err := ss.TargetValues()
+			if err != nil {
+				return err
+			}
+
+			targets, err := ss.SourceTargets(targetValues)
+			if err != nil {
+				return fmt.Errorf(package_errors.BuildSourceConfigError, err)
+			}
+
 			safeFs := &storage.SafeFs{
-				Fs: osFs,
+				Fs:             osFs,
+				ConflictPolicy: conflictPolicy,
 			}
 
 			mu := sync.Mutex{}
@@ -84,7 +108,13 @@ command documentation for an easy way to produce values files.`,
 			go func() {
 				defer wg.Done()
 				for b := range billyChan {
-					_ = safeFs.CopyFileSystemSafe(b, "/", sourceCmdCfg.OutputPath)
+					dest := filepath.Join(sourceCmdCfg.OutputPath, targets[b.Source.Alias])
+					e := safeFs.CopySourceSafe(b.Source.Alias, b.Fs, "/", dest)
+					if e != nil {
+						mu.Lock()
+						receivedErrors = append(receivedErrors, e)
+						mu.Unlock()
+					}
 				}
 			}()
 
@@ -115,10 +145,11 @@ command documentation for an easy way to produce values files.`,
 				return err
 			}
 
-			// Values population
+			// Values population, the source set's values are defaults below the values file or interactive input
 			ts.CreateTemplateValuesMap()
 
 			if sourceCmdCfg.ValuesFilePath == "" {
+				ts.ApplyValueDefaults(ss.ValueDefaults())
 				e := ts.InteractiveInput()
 				if e != nil {
 					return e
@@ -129,10 +160,15 @@ command documentation for an easy way to produce values files.`,
 					return fmt.Errorf(package_errors.OpenValuesFileError, e)
 				}
 
-				ts.TemplateValuesMap, e = services.ReadYamlFromFile[types.TemplateValuesMap](f)
+				fileValues, e := services.ReadYamlFromFile[types.TemplateValuesMap](f)
 				if e != nil {
 					return fmt.Errorf(package_errors.OpenValuesFileError, e)
 				}
+
+				// Only values the file or the source set give are set, so missing ones still fail templates.
+				ts.ApplyValueDefaults(ss.ValueDefaults())
+				ts.DropUnsetValues()
+				ts.MergeValues(fileValues)
 			}
 
 			err = ts.ExecuteTemplates()
@@ -144,6 +180,8 @@ command documentation for an easy way to produce values files.`,
 			if err != nil {
 				return fmt.Errorf(package_errors.TemplateFileRenameError, err)
 			}
+
+			writeConflictReport(cmd.ErrOrStderr(), sourceCmdCfg.OutputPath, safeFs.Conflicts())
 			return nil
 		},
 	}
@@ -163,6 +201,12 @@ command documentation for an easy way to produce values files.`,
 	ProjectCmd.Flags().StringSliceVar(
 		&sourceCmdCfg.Sources, "sources", []string{}, "list of sources (defined in the sources config file) this execution will build",
 	)
+	ProjectCmd.Flags().StringSliceVar(
+		&sourceCmdCfg.AddSources, "add-source", []string{}, "sources (defined in the sources config file) to build after those of the source set",
+	)
+	ProjectCmd.Flags().StringSliceVar(
+		&sourceCmdCfg.ExcludeSources, "exclude-source", []string{}, "sources of the source set this execution will not build",
+	)
 	ProjectCmd.Flags().StringVarP(
 		&sourceCmdCfg.ValuesFilePath, "values-file", "f", "", "path to a values file used to populate template values. falls into interactive mode if not provided.",
 	)
@@ -170,9 +214,35 @@ command documentation for an easy way to produce values files.`,
 		&sourceCmdCfg.FailOnMissingTemplateValue, "fail-on-missing-value", "m", false, "whether to fail project generation is a template input value is missing.",
 	)
 
+	ProjectCmd.Flags().BoolVar(
+		&sourceCmdCfg.Offline, "offline", false, "render sources from the source cache only, without fetching them",
+	)
+	ProjectCmd.Flags().BoolVar(
+		&sourceCmdCfg.Refresh, "refresh", false, "fetch every source even when the source cache is current",
+	)
+
 	_ = ProjectCmd.MarkFlagRequired("output-path")
 	ProjectCmd.MarkFlagsOneRequired("source-set", "sources")
 	ProjectCmd.MarkFlagsMutuallyExclusive("source-set", "sources")
+	ProjectCmd.MarkFlagsMutuallyExclusive("sources", "add-source")
+	ProjectCmd.MarkFlagsMutuallyExclusive("sources", "exclude-source")
+	ProjectCmd.MarkFlagsMutuallyExclusive("offline", "refresh")
 
 	return ProjectCmd
 }
+
+// writeConflictReport lists each file more than one source wrote under root, the sources, and how it was resolved.
+func writeConflictReport(w io.Writer, root string, conflicts []storage.Conflict) {
+	if len(conflicts) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "%d files were written by more than one source:\n", len(conflicts))
+	for _, c := range conflicts {
+		p, err := filepath.Rel(root, c.Path)
+		if err != nil {
+			p = c.Path
+		}
+		_, _ = fmt.Fprintf(w, "  %s: %s (%s)\n", p, strings.Join(c.Sources, ", "), c.Strategy)
+	}
+}
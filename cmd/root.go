@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/OneFineDev/tmpltr/internal/logger"
+	"github.com/OneFineDev/tmpltr/internal/services"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -15,30 +21,51 @@ import (
 
 const (
 	// Viper can access a nested field in configs by passing a . delimited path of keys. Viper lookups are case insensitive.
-	rootCfgKeyVerbose          string = "verbose"
-	rootCfgKeyFlagDebug        string = "flagDebug"
-	rootCfgKeySourceConfigFile string = "sourceConfigFile"
-	rootCfgKeyLoggingLevel     string = "logging.level"
-	rootCfgKeyLoggingFormat    string = "logging.format"
-	rootCfgKeyLoggingOutputs   string = "logging.outputs"
+	rootCfgKeyVerbose             string = "verbose"
+	rootCfgKeyFlagDebug           string = "flagDebug"
+	rootCfgKeySourceConfigFile    string = "sourceConfigFile"
+	rootCfgKeySourceConfigFiles   string = "sourceConfigFiles"
+	rootCfgKeyCatalogs            string = "catalogs"
+	rootCfgKeyCatalogRef          string = "catalogRef"
+	rootCfgKeyTrustCatalogSecrets string = "trustCatalogSecrets"
+	rootCfgKeyCacheDir            string = "cacheDir"
+	rootCfgKeyConcurrency         string = "concurrency"
+	rootCfgKeySourceTimeout       string = "sourceTimeout"
+	rootCfgKeyRetries             string = "retries"
+	rootCfgKeyLoggingLevel        string = "logging.level"
+	rootCfgKeyLoggingFormat       string = "logging.format"
+	rootCfgKeyLoggingOutputs      string = "logging.outputs"
 )
 
+// defaultSourceConfigFile is the sources config file read when no other is set.
+const defaultSourceConfigFile = "$HOME/.tmpltr/.sources.yaml"
+
 var (
 	globalCfg = &GlobalConfig{} //nolint:gochecknoglobals //will fix
 	cfgFile   string            //nolint:gochecknoglobals //will fix
 
 	// Preserves the flag/config override logic in bindFlags() even with nested config keys in config file.
 	flagToViperKeyLookup = map[string]string{ //nolint:gochecknoglobals //will fix
-		"log-level":          rootCfgKeyLoggingLevel,
-		"log-format":         rootCfgKeyLoggingFormat,
-		"log-output":         rootCfgKeyLoggingOutputs,
-		"source-config-file": rootCfgKeySourceConfigFile,
-		"verbose":            rootCfgKeyVerbose,
+		"log-level":             rootCfgKeyLoggingLevel,
+		"log-format":            rootCfgKeyLoggingFormat,
+		"log-output":            rootCfgKeyLoggingOutputs,
+		"source-config-file":    rootCfgKeySourceConfigFile,
+		"source-config-files":   rootCfgKeySourceConfigFiles,
+		"catalog-ref":           rootCfgKeyCatalogRef,
+		"trust-catalog-secrets": rootCfgKeyTrustCatalogSecrets,
+		"cache-dir":             rootCfgKeyCacheDir,
+		"concurrency":           rootCfgKeyConcurrency,
+		"source-timeout":        rootCfgKeySourceTimeout,
+		"retries":               rootCfgKeyRetries,
+		"verbose":               rootCfgKeyVerbose,
 	}
 )
 
 var appLogger *slog.Logger //nolint:gochecknoglobals //will fix
 
+// newSourceService creates the SourceService of commands that fetch sources.
+var newSourceService = services.NewSourceService //nolint:gochecknoglobals // replaced in tests
+
 func NewRootCommand() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "tmpltr",
@@ -69,7 +96,30 @@ func NewRootCommand() *cobra.Command {
 		&cfgFile, "config", "", "path to folder containing config file (not the path of the file itself)",
 	)
 	rootCmd.PersistentFlags().StringVarP(
-		&globalCfg.SourceConfigFile, "source-config-file", "s", "$HOME/.tmpltr/.sources.yaml", "path to sources config file",
+		&globalCfg.SourceConfigFile, "source-config-file", "s", defaultSourceConfigFile, "path to sources config file",
+	)
+	rootCmd.PersistentFlags().StringSliceVar(
+		&globalCfg.SourceConfigFiles, "source-config-files", nil,
+		"sources config files merged after --source-config-file, local paths or <git url>//<path>[?ref=<ref>]",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&globalCfg.CatalogRef, "catalog-ref", "", "branch, tag or commit every git-hosted sources config file is read at",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&globalCfg.TrustCatalogSecrets, "trust-catalog-secrets", false,
+		"let auths from git-hosted sources config files use secret references and TMLPTR_<alias>_PAT overrides",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&globalCfg.CacheDir, "cache-dir", "$HOME/.tmpltr/cache", "path to the directory fetched sources are cached in",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&globalCfg.Concurrency, "concurrency", 4, "maximum number of sources fetched at once, no limit when 0", //nolint:mnd
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&globalCfg.SourceTimeout, "source-timeout", 5*time.Minute, "time allowed for each attempt at fetching a source, no limit when 0", //nolint:mnd
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&globalCfg.Retries, "retries", 3, "number of times fetching a source is retried after a transient network error", //nolint:mnd
 	)
 	rootCmd.PersistentFlags().BoolVarP(
 		&globalCfg.Verbose, "verbose", "v", false, "Verbose mode",
@@ -87,6 +137,7 @@ func NewRootCommand() *cobra.Command {
 	rootCmd.AddCommand(
 		NewGetCommand(),
 		NewCreateCommand(),
+		NewCacheCommand(),
 		NewProjectCommand(),
 		NewVersionCommand(),
 	)
@@ -96,14 +147,48 @@ func NewRootCommand() *cobra.Command {
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the command's context, so fetches in progress stop rather than hang.
 func Execute() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Once cancelled, a second signal kills the process as it would without the handler.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	cmd := NewRootCommand()
-	err := cmd.Execute()
+	err := cmd.ExecuteContext(ctx)
 	if err != nil {
-		os.Exit(1)
+		os.Exit(1) //nolint:gocritic // stop only restores the default signal handling
 	}
 }
 
+/*
+sourceConfigLocations returns the source config files to load: catalogs, then sourceConfigFile, unless
+it's left at its default while catalogs or sourceConfigFiles are listed, then sourceConfigFiles, each
+listed once.
+*/
+func sourceConfigLocations() []string {
+	locations := make([]string, 0, len(globalCfg.Catalogs))
+	for _, catalog := range globalCfg.Catalogs {
+		locations = append(locations, catalog.Location())
+	}
+
+	listed := len(globalCfg.Catalogs) > 0 || len(globalCfg.SourceConfigFiles) > 0
+	if globalCfg.SourceConfigFile != "" && (globalCfg.SourceConfigFile != defaultSourceConfigFile || !listed) {
+		locations = append(locations, globalCfg.SourceConfigFile)
+	}
+
+	for _, location := range globalCfg.SourceConfigFiles {
+		if !slices.Contains(locations, location) {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig(cmd *cobra.Command) error {
 	if cfgFile != "" {
@@ -125,7 +210,6 @@ func initConfig(cmd *cobra.Command) error {
 
 	viper.SetEnvPrefix("TMPLTR")
 	viper.SetDefault(rootCfgKeyVerbose, false)
-	viper.SetDefault(rootCfgKeySourceConfigFile, "/home/parisb/repos/PLT.PRODUCT.TMPLTR/tmpltr/test/.tmpltr")
 	viper.SetDefault(rootCfgKeyLoggingFormat, "text")
 	viper.SetDefault(rootCfgKeyLoggingLevel, "INFO")
 	viper.SetDefault(rootCfgKeyLoggingOutputs, []string{"StdOut"})
@@ -142,6 +226,11 @@ func initConfig(cmd *cobra.Command) error {
 		return fmt.Errorf("config read error %s: ", err.Error())
 	}
 
+	// Catalogs are a list of objects, which flags can't hold, so they're only read from the config file.
+	if err := viper.UnmarshalKey(rootCfgKeyCatalogs, &globalCfg.Catalogs); err != nil {
+		return fmt.Errorf("config read error %s: ", err.Error())
+	}
+
 	bindFlags(cmd, viper.GetViper())
 	return nil
 }
@@ -162,6 +251,10 @@ func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 					val,
 				)
 			}
+			if f.Value.Type() == "stringSlice" {
+				// Lists from the config file are set as one comma separated value, as the flag parses them.
+				val = strings.Join(v.GetStringSlice(flagToViperKeyLookup[configName]), ",")
+			}
 			_ = cmd.Flags().Set(f.Name, fmt.Sprintf("%v", val))
 		}
 	})
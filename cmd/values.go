@@ -1,9 +1,7 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"os"
 	"sync"
 
 	"github.com/OneFineDev/tmpltr/internal/services"
@@ -11,7 +9,6 @@ import (
 	package_errors "github.com/OneFineDev/tmpltr/internal/tmpltrerrors"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 const tempPath string = "temp" //  since this will always run in mem, making the "output" path constant
@@ -27,31 +24,44 @@ func NewValuesCommand() *cobra.Command { //nolint:funlen
 	This application is a tool to generate the needed files
 	to quickly Values a Cobra application.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			sourceConfigFile, err := os.Open(globalCfg.SourceConfigFile)
-			if err != nil {
-				return fmt.Errorf("error opening source config file: %w", err)
-			}
-			defer sourceConfigFile.Close()
+			ctx := cmd.Context()
 
-			parsedSorcesConfig, err := services.ParseSourceConfigFile(sourceConfigFile)
+			var err error
+			sourceCmdCfg.CacheDir, err = storage.ExpandPath(globalCfg.CacheDir)
 			if err != nil {
-				return fmt.Errorf("error parsing source config file: %w", err)
+				return fmt.Errorf("failed to resolve cache directory: %w", err)
 			}
+			sourceCmdCfg.Concurrency = globalCfg.Concurrency
+			sourceCmdCfg.SourceTimeout = globalCfg.SourceTimeout
+			sourceCmdCfg.Retries = globalCfg.Retries
+			sourceCmdCfg.CatalogRef = globalCfg.CatalogRef
+			sourceCmdCfg.TrustCatalogSecrets = globalCfg.TrustCatalogSecrets
+			sourceCmdCfg.CatalogAuths = catalogAuths()
 
 			ss := services.NewSourceService(sourceCmdCfg, appLogger, cmd.Name())
 
 			ss.Logger.Info("values called")
 
+			parsedSorcesConfig, err := ss.LoadSourceConfig(ctx, afero.NewOsFs(), sourceConfigLocations())
+			if err != nil {
+				return fmt.Errorf("error parsing source config file: %w", err)
+			}
+
 			err = ss.BuildProjectSourceConfigs(parsedSorcesConfig)
 			if err != nil {
 				return fmt.Errorf("error building source configs: %w", err)
 			}
-			ctx := context.Background()
 
 			memFs := afero.NewMemMapFs()
 
+			conflictPolicy, err := ss.ConflictPolicy()
+			if err != nil {
+				return fmt.Errorf("error building source configs: %w", err)
+			}
+
 			safeFs := &storage.SafeFs{
-				Fs: memFs,
+				Fs:             memFs,
+				ConflictPolicy: conflictPolicy,
 			}
 
 			mu := sync.Mutex{}
@@ -68,8 +78,15 @@ func NewValuesCommand() *cobra.Command { //nolint:funlen
 			wg.Add(2) //nolint:mnd
 			go func() {
 				defer wg.Done()
+				// Targets only move files within the project, and may need the values this command lists, so
+				// every source is read at the root.
 				for b := range billyChan {
-					_ = safeFs.CopyFileSystemSafe(b, "/", tempPath)
+					e := safeFs.CopySourceSafe(b.Source.Alias, b.Fs, "/", tempPath)
+					if e != nil {
+						mu.Lock()
+						receivedErrors = append(receivedErrors, e)
+						mu.Unlock()
+					}
 				}
 			}()
 
@@ -116,10 +133,11 @@ func NewValuesCommand() *cobra.Command { //nolint:funlen
 
 			// Values population
 			ts.CreateTemplateValuesMap()
+			prefilled := ts.ApplyValueDefaults(ss.ValueDefaults())
 
 			out := cmd.OutOrStdout()
 
-			p, err := yaml.Marshal(ts.TemplateValuesMap)
+			p, err := ts.MarshalValues(prefilled, "default from source set "+sourceCmdCfg.SourceSet)
 			if err != nil {
 				ss.Logger.Error(err.Error())
 				return err
@@ -137,9 +155,25 @@ func NewValuesCommand() *cobra.Command { //nolint:funlen
 	ValuesCmd.Flags().StringSliceVar(
 		&sourceCmdCfg.Sources, "sources", []string{}, "list of sources (defined in the sources config file) this execution will build",
 	)
+	ValuesCmd.Flags().StringSliceVar(
+		&sourceCmdCfg.AddSources, "add-source", []string{}, "sources (defined in the sources config file) to build after those of the source set",
+	)
+	ValuesCmd.Flags().StringSliceVar(
+		&sourceCmdCfg.ExcludeSources, "exclude-source", []string{}, "sources of the source set this execution will not build",
+	)
+
+	ValuesCmd.Flags().BoolVar(
+		&sourceCmdCfg.Offline, "offline", false, "render sources from the source cache only, without fetching them",
+	)
+	ValuesCmd.Flags().BoolVar(
+		&sourceCmdCfg.Refresh, "refresh", false, "fetch every source even when the source cache is current",
+	)
 
 	ValuesCmd.MarkFlagsOneRequired("source-set", "sources")
 	ValuesCmd.MarkFlagsMutuallyExclusive("source-set", "sources")
+	ValuesCmd.MarkFlagsMutuallyExclusive("sources", "add-source")
+	ValuesCmd.MarkFlagsMutuallyExclusive("sources", "exclude-source")
+	ValuesCmd.MarkFlagsMutuallyExclusive("offline", "refresh")
 
 	return ValuesCmd
 }
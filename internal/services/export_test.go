@@ -3,11 +3,18 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseSourceSets(t *testing.T) {
@@ -16,6 +23,7 @@ func TestParseSourceSets(t *testing.T) {
 		name               string
 		sourceSets         []types.SourceSet
 		expectedSourceSets map[string]types.SourceSet
+		expectedErr        string
 	}{
 		{
 			name: "Basic source sets",
@@ -106,6 +114,85 @@ func TestParseSourceSets(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Source sets extending sets",
+			sourceSets: []types.SourceSet{
+				{
+					Alias:   "goWebSet",
+					Extends: []string{"baseGoSet"},
+					Sources: []string{"goWeb", "vscode"},
+					Values:  map[string]string{"goVersion": "1.24", "port": "8080"},
+				},
+				{
+					Alias:   "baseGoSet",
+					Extends: []string{"baseSet"},
+					Sources: []string{"goTooling", "vscode"},
+					Values:  map[string]string{"goVersion": "1.23"},
+				},
+				{
+					Alias:   "baseSet",
+					Sources: []string{"common", "doc"},
+					Values:  map[string]string{"owner": "platform"},
+				},
+			},
+			expectedSourceSets: map[string]types.SourceSet{
+				"goWebSet": {
+					Alias:   "goWebSet",
+					Sources: []string{"common", "doc", "goTooling", "vscode", "goWeb"},
+					Values:  map[string]string{"owner": "platform", "goVersion": "1.24", "port": "8080"},
+				},
+				"baseGoSet": {
+					Alias:   "baseGoSet",
+					Sources: []string{"common", "doc", "goTooling", "vscode"},
+					Values:  map[string]string{"owner": "platform", "goVersion": "1.23"},
+				},
+				"baseSet": {
+					Alias:   "baseSet",
+					Sources: []string{"common", "doc"},
+					Values:  map[string]string{"owner": "platform"},
+				},
+			},
+		},
+		{
+			name: "Source set extending several sets",
+			sourceSets: []types.SourceSet{
+				{Alias: "fullSet", Extends: []string{"goSet", "docSet"}, Sources: []string{"app"}},
+				{Alias: "goSet", Sources: []string{"goTooling", "common"}, Values: map[string]string{"lint": "go"}},
+				{Alias: "docSet", Sources: []string{"doc", "common"}, Values: map[string]string{"lint": "md"}},
+			},
+			expectedSourceSets: map[string]types.SourceSet{
+				"fullSet": {
+					Alias:   "fullSet",
+					Sources: []string{"goTooling", "common", "doc", "app"},
+					Values:  map[string]string{"lint": "md"},
+				},
+				"goSet":  {Alias: "goSet", Sources: []string{"goTooling", "common"}, Values: map[string]string{"lint": "go"}},
+				"docSet": {Alias: "docSet", Sources: []string{"doc", "common"}, Values: map[string]string{"lint": "md"}},
+			},
+		},
+		{
+			name: "Source set extending an unknown set",
+			sourceSets: []types.SourceSet{
+				{Alias: "goWebSet", Extends: []string{"baseGoSet"}, Sources: []string{"goWeb"}},
+			},
+			expectedErr: "source set goWebSet extends unknown source set baseGoSet",
+		},
+		{
+			name: "Source sets extending each other",
+			sourceSets: []types.SourceSet{
+				{Alias: "a", Extends: []string{"b"}},
+				{Alias: "b", Extends: []string{"c"}},
+				{Alias: "c", Extends: []string{"a"}},
+			},
+			expectedErr: "source set a extends itself: a -> b -> c -> a",
+		},
+		{
+			name: "Source set extending itself",
+			sourceSets: []types.SourceSet{
+				{Alias: "a", Extends: []string{"a"}},
+			},
+			expectedErr: "source set a extends itself: a -> a",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -119,9 +206,14 @@ func TestParseSourceSets(t *testing.T) {
 			}
 
 			// Act
-			sourceService.parseSourceSets()
+			err := sourceService.parseSourceSets()
 
 			// Assert
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
 			assert.Equal(t, len(tc.expectedSourceSets), len(sourceService.SourceSets), //nolint:testifylint //no prob
 				"SourceSets should have the expected number of entries")
 
@@ -428,6 +520,20 @@ func TestParseSourceAuths(t *testing.T) {
 				"EMPTY": {AuthAlias: "EMPTY", UserName: "empty-user"},
 			},
 		},
+		{
+			name: "Source auths with ssh key passphrase from environment",
+			sourceAuths: []types.SourceAuth{
+				{AuthAlias: "GITHUB", SSHKey: "/path/to/github.key"},
+				{AuthAlias: "GITLAB", SSHKey: "/path/to/gitlab.key", SSHKeyPassphrase: "configured"},
+			},
+			environmentVars: map[string]string{
+				"TMLPTR_GITHUB_SSH_PASSPHRASE": "github-passphrase",
+			},
+			expectedSourceAuths: map[string]types.SourceAuth{
+				"GITHUB": {AuthAlias: "GITHUB", SSHKey: "/path/to/github.key", SSHKeyPassphrase: "github-passphrase"},
+				"GITLAB": {AuthAlias: "GITLAB", SSHKey: "/path/to/gitlab.key", SSHKeyPassphrase: "configured"},
+			},
+		},
 		{
 			name:        "Empty source auths list",
 			sourceAuths: []types.SourceAuth{},
@@ -478,6 +584,8 @@ func TestParseSourceAuths(t *testing.T) {
 					"Pat should match for %s", authAlias)
 				assert.Equal(t, expectedAuth.SSHKey, actualAuth.SSHKey,
 					"SshKey should match for %s", authAlias)
+				assert.Equal(t, expectedAuth.SSHKeyPassphrase, actualAuth.SSHKeyPassphrase,
+					"SSHKeyPassphrase should match for %s", authAlias)
 				assert.Equal(t, expectedAuth.Key, actualAuth.Key,
 					"Key should match for %s", authAlias)
 				assert.Equal(t, expectedAuth.Token, actualAuth.Token,
@@ -486,3 +594,155 @@ func TestParseSourceAuths(t *testing.T) {
 		})
 	}
 }
+
+func TestWithRetry(t *testing.T) {
+	errTransient := &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}
+	errPermanent := errors.New("repository not found")
+
+	// Arrange
+	testCases := []struct {
+		name             string
+		retries          int
+		sourceTimeout    time.Duration
+		failures         []error
+		hang             bool
+		cancelAfter      time.Duration
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{
+			name:             "succeeds first time",
+			retries:          3,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "retries transient errors until it succeeds",
+			retries:          3,
+			failures:         []error{errTransient, errTransient},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up once the retries are used",
+			retries:          2,
+			failures:         []error{errTransient, errTransient, errTransient, errTransient},
+			expectedAttempts: 3,
+			expectedErr:      syscall.ECONNREFUSED,
+		},
+		{
+			name:             "does not retry errors that are not transient",
+			retries:          3,
+			failures:         []error{errPermanent},
+			expectedAttempts: 1,
+			expectedErr:      errPermanent,
+		},
+		{
+			name:             "times out and retries hung attempts",
+			retries:          1,
+			sourceTimeout:    10 * time.Millisecond,
+			hang:             true,
+			expectedAttempts: 2,
+			expectedErr:      context.DeadlineExceeded,
+		},
+		{
+			name:             "stops waiting to retry on cancellation",
+			retries:          3,
+			failures:         []error{errTransient},
+			cancelAfter:      10 * time.Millisecond,
+			expectedAttempts: 1,
+			expectedErr:      context.Canceled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ss := &SourceService{
+				SourcesCommandConfig: &SourcesCommandConfig{Retries: tc.retries, SourceTimeout: tc.sourceTimeout},
+				Logger:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+				retryDelay:           time.Millisecond,
+			}
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if tc.cancelAfter > 0 {
+				ss.retryDelay = time.Minute
+				time.AfterFunc(tc.cancelAfter, cancel)
+			}
+
+			attempts := 0
+			op := func(ctx context.Context) error {
+				attempts++
+				if tc.hang {
+					<-ctx.Done()
+					return errors.New("connection stalled")
+				}
+				if attempts <= len(tc.failures) {
+					return tc.failures[attempts-1]
+				}
+				return nil
+			}
+
+			// Act
+			err := ss.withRetry(ctx, "templates", op)
+
+			// Assert
+			assert.Equal(t, tc.expectedAttempts, attempts)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestSplitGitLocation(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name         string
+		location     string
+		expectedURL  string
+		expectedPath string
+		expectedRef  string
+		expectedGit  bool
+	}{
+		{
+			name:         "https url",
+			location:     "https://github.com/org/catalog.git//tmpltr/sources.yaml",
+			expectedURL:  "https://github.com/org/catalog.git",
+			expectedPath: "tmpltr/sources.yaml",
+			expectedGit:  true,
+		},
+		{
+			name:         "scp-like url with ref",
+			location:     "git@ssh.dev.azure.com:v3/org/project/catalog//sources.yaml?ref=v1.2.0",
+			expectedURL:  "git@ssh.dev.azure.com:v3/org/project/catalog",
+			expectedPath: "sources.yaml",
+			expectedRef:  "v1.2.0",
+			expectedGit:  true,
+		},
+		{
+			name:     "local path",
+			location: "/home/user/.tmpltr/.sources.yaml",
+		},
+		{
+			name:     "local path with a double slash",
+			location: "/home/user//sources.yaml",
+		},
+		{
+			name:     "url without a file path",
+			location: "https://github.com/org/catalog.git",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			url, filePath, ref, ok := splitGitLocation(tt.location)
+
+			// Assert
+			assert.Equal(t, tt.expectedGit, ok)
+			assert.Equal(t, tt.expectedURL, url)
+			assert.Equal(t, tt.expectedPath, filePath)
+			assert.Equal(t, tt.expectedRef, ref)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+)
+
+const (
+	// defaultRetryDelay is the wait before the first retry, doubled before each retry after it.
+	defaultRetryDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the wait between retries.
+	maxRetryDelay = 30 * time.Second
+)
+
+/*
+withRetry runs op for the source alias, giving each attempt SourceTimeout when it is set. Attempts that
+fail with a transient error, see storage.IsTransient, are retried up to Retries times with an
+exponential backoff. Cancelling ctx stops the attempt in progress and any retries to come.
+*/
+func (ss *SourceService) withRetry(ctx context.Context, alias string, op func(ctx context.Context) error) error {
+	delay := ss.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := ss.attempt(ctx, op)
+		if err == nil || attempt > ss.Retries || ctx.Err() != nil || !storage.IsTransient(err) {
+			return err
+		}
+
+		ss.Logger.Warn(
+			logMsgRetry, logKeyGitRepo, alias, logKeyAttempt, attempt, logKeyDelay, delay.String(), logKeyErr, err.Error(),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay = min(delay*2, maxRetryDelay) //nolint:mnd
+	}
+}
+
+// attempt runs op once, failing it with context.DeadlineExceeded when it outlasts SourceTimeout.
+func (ss *SourceService) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if ss.SourceTimeout <= 0 {
+		return op(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, ss.SourceTimeout)
+	defer cancel()
+
+	err := op(attemptCtx)
+	if err == nil || ctx.Err() != nil || !errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	// Clients don't all surface the deadline in their errors, so it's added where it's missing.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", ss.SourceTimeout, err)
+	}
+	return fmt.Errorf("timed out after %s: %w: %w", ss.SourceTimeout, context.DeadlineExceeded, err)
+}
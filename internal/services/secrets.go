@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+)
+
+const (
+	SecretSchemeEnv     = "env"
+	SecretSchemeFile    = "file"
+	SecretSchemeCmd     = "cmd"
+	SecretSchemeKeyring = "keyring"
+)
+
+// keyringService is the service secrets referenced by keyring:<name> are stored under, with name as the account.
+const keyringService = "tmpltr"
+
+var errKeyringUnsupported = errors.New("no supported keyring on " + runtime.GOOS)
+
+/*
+SecretResolver resolves a secret reference, the part of a SourceAuth field after its scheme such as
+GITHUB_TOKEN in env:GITHUB_TOKEN, to the secret it refers to.
+*/
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts an ordinary function to a SecretResolver.
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+/*
+DefaultSecretResolvers returns the resolvers for the built in schemes, keyed by scheme:
+  - env:VAR reads the environment variable VAR.
+  - file:/path reads the file at path, ~ and environment variables are expanded.
+  - cmd:command runs command in the shell and reads its output.
+  - keyring:name reads the password stored for account name under the tmpltr service in the OS keyring.
+
+Trailing newlines are trimmed from files and command output.
+*/
+func DefaultSecretResolvers() map[string]SecretResolver {
+	return map[string]SecretResolver{
+		SecretSchemeEnv:     SecretResolverFunc(resolveEnvSecret),
+		SecretSchemeFile:    SecretResolverFunc(resolveFileSecret),
+		SecretSchemeCmd:     SecretResolverFunc(resolveCmdSecret),
+		SecretSchemeKeyring: SecretResolverFunc(resolveKeyringSecret),
+	}
+}
+
+func resolveEnvSecret(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+func resolveFileSecret(ref string) (string, error) {
+	p, err := storage.ExpandPath(ref)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func resolveCmdSecret(ref string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return runSecretCommand("cmd", "/C", ref)
+	}
+	return runSecretCommand("sh", "-c", ref)
+}
+
+func resolveKeyringSecret(ref string) (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return runSecretCommand("security", "find-generic-password", "-s", keyringService, "-a", ref, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return runSecretCommand("secret-tool", "lookup", "service", keyringService, "account", ref)
+	default:
+		return "", errKeyringUnsupported
+	}
+}
+
+// runSecretCommand runs name with args and returns its output, with any error output added to a failure.
+func runSecretCommand(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+/*
+resolveSecret returns value with its secret reference resolved by the resolver registered for its
+scheme. Values without the prefix of a registered scheme are returned as they are. An untrusted
+value, from a catalog anyone with push access can change, can't use any secret reference unless
+TrustCatalogSecrets is set, as any of them would let a catalog run commands or send local secrets,
+environment variables included, to a URL of its choosing.
+*/
+func (ss *SourceService) resolveSecret(value string, untrusted bool) (string, error) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, nil
+	}
+
+	resolver, ok := ss.SecretResolvers[scheme]
+	if !ok {
+		return value, nil
+	}
+
+	if untrusted && !ss.TrustCatalogSecrets {
+		return "", fmt.Errorf(
+			"%s references aren't allowed in auths from a catalog unless trustCatalogSecrets is set", scheme,
+		)
+	}
+
+	return resolver.Resolve(ref)
+}
+
+// resolveSourceAuthSecrets resolves secret references in the credential fields of sourceAuth in place.
+func (ss *SourceService) resolveSourceAuthSecrets(sourceAuth *types.SourceAuth) error {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"pat", &sourceAuth.Pat},
+		{"token", &sourceAuth.Token},
+		{"key", &sourceAuth.Key},
+		{"sshKeyPath", &sourceAuth.SSHKey},
+		{"sshKeyPassphrase", &sourceAuth.SSHKeyPassphrase},
+	}
+
+	for _, field := range fields {
+		resolved, err := ss.resolveSecret(*field.value, sourceAuth.Untrusted)
+		if err != nil {
+			return fmt.Errorf("source auth %s: failed to resolve %s %q: %w",
+				sourceAuth.AuthAlias, field.name, *field.value, err)
+		}
+		*field.value = resolved
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// gitLocationPathSeparator separates a git repository URL from the path of a file in it.
+	gitLocationPathSeparator = "//"
+	// gitLocationRefParam sets the ref a file in a git repository is read at.
+	gitLocationRefParam = "?ref="
+	// namespaceSeparator separates a namespace from the aliases in it.
+	namespaceSeparator = "/"
+)
+
+// scpLikeGitURL matches git URLs in the scp-like user@host:path syntax.
+var scpLikeGitURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`) //nolint:gochecknoglobals // compiled once
+
+/*
+CatalogAuth is how a catalog's repository is authenticated. Its secret references are resolved like
+those of a trusted SourceAuth, as it's set locally rather than read from a catalog.
+*/
+type CatalogAuth struct {
+	types.SourceAuth
+
+	KnownHostsPath string
+}
+
+/*
+SourceConfigFile is the content of a source config file and where it was read from. Untrusted is set
+for catalogs, whose auths are marked Untrusted, see resolveSecret.
+*/
+type SourceConfigFile struct {
+	Location  string
+	Data      []byte
+	Untrusted bool
+}
+
+/*
+LoadSourceConfig reads the source config files at locations, in order, and merges them, see
+MergeSourceConfigs. A location is a path on fs or a file in a git repository, a catalog, written as the
+repository URL and the file's path in it separated by //, with an optional ?ref= for the branch, tag or
+commit to read it at, e.g. https://github.com/org/catalog.git//tmpltr/sources.yaml?ref=v1. Catalogs
+are fetched and cached like git sources, see fetchSources, and read at CatalogRef when it is set. They
+are authenticated with their CatalogAuths entry or, over http(s) without one, with git's credential
+helpers when git holds credentials for them. git never asks for credentials on the terminal then, so a
+public catalog is fetched anonymously straight away.
+*/
+func (ss *SourceService) LoadSourceConfig(
+	ctx context.Context, fs afero.Fs, locations []string,
+) (*types.SourceConfig, error) {
+	files := make([]SourceConfigFile, 0, len(locations))
+	for _, location := range locations {
+		data, err := ss.readSourceConfigFile(ctx, fs, location)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+		_, _, _, catalog := splitGitLocation(location)
+		files = append(files, SourceConfigFile{Location: location, Data: data, Untrusted: catalog})
+	}
+
+	return MergeSourceConfigs(files)
+}
+
+func (ss *SourceService) readSourceConfigFile(ctx context.Context, fs afero.Fs, location string) ([]byte, error) {
+	url, filePath, ref, ok := splitGitLocation(location)
+	if !ok {
+		p, err := storage.ExpandPath(location)
+		if err != nil {
+			return nil, err
+		}
+		return afero.ReadFile(fs, p)
+	}
+
+	if ss.CatalogRef != "" {
+		ref = ss.CatalogRef
+	}
+
+	catalogAuth, configured := ss.CatalogAuths[url]
+	helperFallback := !configured && (strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://"))
+	if helperFallback {
+		catalogAuth.CredentialHelper = types.GitCredentialHelper
+	}
+	sourceAuth := catalogAuth.SourceAuth
+	if err := ss.resolveSourceAuthSecrets(&sourceAuth); err != nil {
+		return nil, err
+	}
+
+	filePath = path.Clean("/" + filePath)
+	client := storage.NewGitClient()
+	client.PassphrasePrompt = ss.PassphrasePrompt
+	catalog := types.Source{
+		SourceType:     types.GitSourceType,
+		Alias:          location,
+		URL:            url,
+		Path:           path.Dir(filePath),
+		Ref:            ref,
+		KnownHostsPath: catalogAuth.KnownHostsPath,
+		SourceAuth:     &sourceAuth,
+		Client:         client,
+	}
+
+	result := ss.fetchSources(ctx, []types.Source{catalog})[0]
+	var helperErr *storage.CredentialHelperError
+	if helperFallback && errors.As(result.err, &helperErr) {
+		// git holds no credentials for the catalog, which may well be public.
+		catalog.SourceAuth = nil
+		result = ss.fetchSources(ctx, []types.Source{catalog})[0]
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return util.ReadFile(result.bfs, path.Base(filePath))
+}
+
+// splitGitLocation splits a location in a git repository into its URL, file path and ref, ok is false for a local path.
+func splitGitLocation(location string) (string, string, string, bool) {
+	start := 0
+	if at := strings.Index(location, "://"); at >= 0 {
+		start = at + len("://")
+	} else if !scpLikeGitURL.MatchString(location) {
+		return "", "", "", false
+	}
+
+	var ref string
+	if at := strings.LastIndex(location, gitLocationRefParam); at >= start {
+		location, ref = location[:at], location[at+len(gitLocationRefParam):]
+	}
+
+	at := strings.Index(location[start:], gitLocationPathSeparator)
+	if at < 0 {
+		return "", "", "", false
+	}
+	at += start
+
+	return location[:at], location[at+len(gitLocationPathSeparator):], ref, true
+}
+
+/*
+MergeSourceConfigs merges the auths, sources and source sets of files into one source config. The
+aliases a file with a Namespace defines, and its references to aliases without a namespace, are
+prefixed with it, e.g. goWeb in the platform namespace is platform/goWeb. So a central catalog can be
+listed next to a team's own file, which refers to the catalog's entries by their namespaced alias.
+Aliases defined more than once are reported with the locations of both definitions.
+*/
+func MergeSourceConfigs(files []SourceConfigFile) (*types.SourceConfig, error) {
+	merged := &types.SourceConfig{}
+	defined := make(map[string]map[string]string)
+
+	var errs []error
+	define := func(kind, alias, location string) {
+		if defined[kind] == nil {
+			defined[kind] = make(map[string]string)
+		}
+		if first, ok := defined[kind][alias]; ok {
+			errs = append(errs, fmt.Errorf("duplicate %s alias %s: defined at %s and %s", kind, alias, first, location))
+			return
+		}
+		defined[kind][alias] = location
+	}
+
+	for _, file := range files {
+		cfg, lines, err := decodeSourceConfig(file.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Location, err)
+		}
+		namespaceSourceConfig(cfg)
+
+		at := func(section string, i int) string {
+			return fmt.Sprintf("%s:%d", file.Location, lines[section][i])
+		}
+		for i, sourceAuth := range cfg.SourceAuths {
+			define("source auth", sourceAuth.AuthAlias, at("sourceAuths", i))
+			cfg.SourceAuths[i].Untrusted = file.Untrusted
+		}
+		for i, source := range cfg.Sources {
+			define("source", source.Alias, at("sources", i))
+		}
+		for i, sourceSet := range cfg.SourceSets {
+			define("source set", sourceSet.Alias, at("sourceSets", i))
+		}
+
+		merged.SourceAuths = append(merged.SourceAuths, cfg.SourceAuths...)
+		merged.Sources = append(merged.Sources, cfg.Sources...)
+		merged.SourceSets = append(merged.SourceSets, cfg.SourceSets...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+/*
+decodeSourceConfig decodes a source config file, along with the line each entry of its sourceAuths,
+sources and sourceSets starts at, by section and index.
+*/
+func decodeSourceConfig(data []byte) (*types.SourceConfig, map[string][]int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, errors.New("no content in file, data length is 0")
+	}
+
+	var cfg types.SourceConfig
+	if err := doc.Decode(&cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode source config: %w", err)
+	}
+
+	lines := make(map[string][]int)
+	root := doc.Content[0]
+	for n := 0; n+1 < len(root.Content); n += 2 {
+		section := root.Content[n].Value
+		for _, entry := range root.Content[n+1].Content {
+			lines[section] = append(lines[section], entry.Line)
+		}
+	}
+
+	return &cfg, lines, nil
+}
+
+// namespaceSourceConfig prefixes the aliases cfg defines, and those it refers to without a namespace, with its Namespace.
+func namespaceSourceConfig(cfg *types.SourceConfig) {
+	if cfg.Namespace == "" {
+		return
+	}
+	qualify := func(alias string) string {
+		if alias == "" || strings.Contains(alias, namespaceSeparator) {
+			return alias
+		}
+		return cfg.Namespace + namespaceSeparator + alias
+	}
+
+	for i := range cfg.SourceAuths {
+		cfg.SourceAuths[i].AuthAlias = qualify(cfg.SourceAuths[i].AuthAlias)
+	}
+
+	for i := range cfg.Sources {
+		cfg.Sources[i].Alias = qualify(cfg.Sources[i].Alias)
+		cfg.Sources[i].SourceAuthAlias = qualify(cfg.Sources[i].SourceAuthAlias)
+	}
+
+	for i := range cfg.SourceSets {
+		sourceSet := &cfg.SourceSets[i]
+		sourceSet.Alias = qualify(sourceSet.Alias)
+		for n := range sourceSet.Sources {
+			sourceSet.Sources[n] = qualify(sourceSet.Sources[n])
+		}
+		for n := range sourceSet.Extends {
+			sourceSet.Extends[n] = qualify(sourceSet.Extends[n])
+		}
+		if sourceSet.Targets != nil {
+			targets := make(map[string]string, len(sourceSet.Targets))
+			for alias, target := range sourceSet.Targets {
+				targets[qualify(alias)] = target
+			}
+			sourceSet.Targets = targets
+		}
+	}
+}
@@ -5,13 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/OneFineDev/tmpltr/internal/storage"
 	package_errors "github.com/OneFineDev/tmpltr/internal/tmpltrerrors"
 	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/OneFineDev/tmpltr/internal/ui"
 	"github.com/go-git/go-billy/v5"
 	"github.com/spf13/afero"
 )
@@ -20,10 +27,19 @@ type (
 	CtxKeyLogger struct{}
 )
 
+// targetValueProjectName is the value source targets get the project name from.
+const targetValueProjectName = "projectName"
+
 const (
-	logMsgGitClone = "git_clone"
-	logKeyGitRepo  = "repo"
-	logKeyErr      = "error"
+	logMsgGitClone   = "git_clone"
+	logMsgCacheHit   = "cache_hit"
+	logMsgCacheStore = "cache_store"
+	logMsgRetry      = "retry"
+	logKeyGitRepo    = "repo"
+	logKeyRef        = "ref"
+	logKeyErr        = "error"
+	logKeyAttempt    = "attempt"
+	logKeyDelay      = "delay"
 )
 
 // SourcesCommandConfig represents the relevant configuration settings for any command leveraging types.
@@ -49,11 +65,50 @@ type SourcesCommandConfig struct {
 	// SourceSet, defined in SourceConfigFile, to be rendered in a given execution
 	SourceSet string
 
+	// Sources, defined in SourceConfigFile, rendered after those of SourceSet in a given execution
+	AddSources []string
+
+	// Sources of SourceSet left out of a given execution
+	ExcludeSources []string
+
 	// Values file path, contents of which are used to populate template values
 	ValuesFilePath string
 
 	// Whether to throw error if template execution detects a missing template input value
 	FailOnMissingTemplateValue bool
+
+	// Path of the on-disk source cache, sources are always fetched when empty
+	CacheDir string
+
+	// Whether to render sources from the cache only, without contacting their remotes
+	Offline bool
+
+	// Whether to fetch every source even when the cache holds its current revision
+	Refresh bool
+
+	// Maximum number of sources fetched at once, no limit when zero or less
+	Concurrency int
+
+	// Time allowed for each attempt at fetching a source, no limit when zero or less
+	SourceTimeout time.Duration
+
+	// Number of times fetching a source is retried after a transient network error
+	Retries int
+
+	// Ref git-hosted source config files, catalogs, are read at in place of their own, see LoadSourceConfig
+	CatalogRef string
+
+	// Whether auths from catalogs may use secret references and the TMLPTR_<alias>_PAT overrides, see resolveSecret
+	TrustCatalogSecrets bool
+
+	// How catalogs are authenticated, keyed by the URL of their repository, see LoadSourceConfig
+	CatalogAuths map[string]CatalogAuth
+}
+
+// SourceContent is the content fetched for a target source.
+type SourceContent struct {
+	Source types.Source
+	Fs     billy.Filesystem
 }
 
 type SourceClient interface {
@@ -79,6 +134,22 @@ type SourceService struct {
 	TargetSources map[string]types.Source
 	SourceToPath  map[string][]string
 	SourceClients map[string]SourceClient
+	Cache         *storage.SourceCache
+
+	// TargetSourceOrder lists the TargetSources in the order they're layered, later sources overriding earlier ones
+	TargetSourceOrder []string
+
+	// PassphrasePrompt asks for the passphrase of encrypted ssh keys used by git sources
+	PassphrasePrompt func(keyPath string) (string, error)
+
+	// SecretResolvers resolve secret references in SourceAuth fields, keyed by the scheme they handle
+	SecretResolvers map[string]SecretResolver
+
+	// NewClient creates the client of a target source from its type
+	NewClient func(t types.SourceType) (types.SourceCloner, error)
+
+	// retryDelay is the backoff before the first retry of a failed fetch, see withRetry
+	retryDelay time.Duration
 }
 
 func NewSourceService(sourcesCommandConfig *SourcesCommandConfig, logger *slog.Logger, cmdName string) *SourceService {
@@ -91,10 +162,20 @@ func NewSourceService(sourcesCommandConfig *SourcesCommandConfig, logger *slog.L
 		cmdName,
 	)
 
-	return &SourceService{
+	ss := &SourceService{
 		SourcesCommandConfig: sourcesCommandConfig,
 		Logger:               cmdLogger,
+		PassphrasePrompt:     ui.NewPassphrasePrompter().Prompt,
+		SecretResolvers:      DefaultSecretResolvers(),
+		NewClient:            createSourceClients,
+		retryDelay:           defaultRetryDelay,
+	}
+
+	if sourcesCommandConfig.CacheDir != "" {
+		ss.Cache = storage.NewSourceCache(afero.NewOsFs(), sourcesCommandConfig.CacheDir)
 	}
+
+	return ss
 }
 
 func ParseSourceConfigFile(file afero.File) (*types.SourceConfig, error) {
@@ -112,10 +193,17 @@ func (ss *SourceService) BuildProjectSourceConfigs(srcConfig *types.SourceConfig
 	ss.SourceClients = make(map[string]SourceClient)
 
 	ss.SourceConfig = srcConfig
-	ss.parseSourceSets()
+	err := ss.parseSourceSets()
+	if err != nil {
+		return err
+	}
 	ss.parseSources()
 	ss.parseSourceAuths()
-	err := ss.setTargetSourcesFromSourceSet(ss.SourceSet)
+	aliases, err := ss.targetSourceAliases()
+	if err != nil {
+		return err
+	}
+	err = ss.setTargetSources(aliases)
 	if err != nil {
 		return err
 	}
@@ -126,43 +214,136 @@ func (ss *SourceService) BuildProjectSourceConfigs(srcConfig *types.SourceConfig
 	return nil
 }
 
-func (ss *SourceService) CloneSources(ctx context.Context) (chan billy.Filesystem, chan error) {
-	billyChan := make(chan billy.Filesystem, len(ss.TargetSources))
+// ConflictPolicy returns the policy the source set sets for files more than one of its sources hold.
+func (ss *SourceService) ConflictPolicy() (storage.ConflictPolicy, error) {
+	sourceSet := ss.SourceSets[ss.SourceSet]
+	policy := storage.ConflictPolicy{Strategy: sourceSet.OnConflict, Rules: sourceSet.Conflicts}
+	if err := policy.Validate(); err != nil {
+		return policy, fmt.Errorf("source set %s: %w", sourceSet.Alias, err)
+	}
+	return policy, nil
+}
+
+// ValueDefaults returns the template values the source set sets, keyed by dotted path, as defaults for its templates.
+func (ss *SourceService) ValueDefaults() map[string]string {
+	return ss.SourceSets[ss.SourceSet].Values
+}
+
+/*
+TargetValues returns the values source targets are rendered with: those in the values file, when
+one is set, and the project name as projectName.
+*/
+func (ss *SourceService) TargetValues() (map[string]any, error) {
+	values := make(map[string]any)
+
+	if ss.ValuesFilePath != "" {
+		f, err := os.Open(ss.ValuesFilePath)
+		if err != nil {
+			return nil, fmt.Errorf(package_errors.OpenValuesFileError, err)
+		}
+		defer f.Close()
+
+		fileValues, err := ReadYamlFromFile[types.TemplateValuesMap](f)
+		if err != nil {
+			return nil, fmt.Errorf(package_errors.ParseSValuesFileError, err)
+		}
+		maps.Copy(values, fileValues)
+	}
+
+	if ss.ProjectName != "" {
+		values[targetValueProjectName] = ss.ProjectName
+	}
+
+	return values, nil
+}
+
+/*
+SourceTargets renders the target of every target source with values and returns them by alias. A
+source's target is its entry in the source set's Targets, or else its own Target. Rendered targets
+are relative paths that can't climb out of the project, an empty target is the project root.
+*/
+func (ss *SourceService) SourceTargets(values map[string]any) (map[string]string, error) {
+	setTargets := ss.SourceSets[ss.SourceSet].Targets
+
+	targets := make(map[string]string, len(ss.TargetSources))
+	for _, alias := range ss.layerOrder() {
+		target := ss.TargetSources[alias].Target
+		if t, ok := setTargets[alias]; ok {
+			target = t
+		}
+
+		tmpl, err := template.New(alias).Option("missingkey=error").Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q for source %s: %w", target, alias, err)
+		}
+
+		var rendered strings.Builder
+		if err = tmpl.Execute(&rendered, values); err != nil {
+			return nil, fmt.Errorf("failed to render target %q for source %s: %w", target, alias, err)
+		}
+
+		targets[alias] = strings.Trim(path.Clean("/"+rendered.String()), "/")
+	}
+
+	return targets, nil
+}
+
+/*
+CloneSources fetches the target sources on a pool of Concurrency workers and streams the content of
+each, or the error that stopped it, on the returned channels. Sources that share a repository, see
+sourceGroups, are fetched once between them. Fetches are bounded by SourceTimeout and retried on
+transient errors, see withRetry, and cancelling ctx stops every fetch in progress.
+
+Whichever fetch finishes first, content is sent in layer order, see layerOrder, so copying it into
+the output in the order it's received lets a file in a later source replace the same file from an
+earlier one.
+*/
+func (ss *SourceService) CloneSources(ctx context.Context) (chan SourceContent, chan error) {
+	billyChan := make(chan SourceContent, len(ss.TargetSources))
 	errChan := make(chan error, len(ss.TargetSources))
 
+	results := make(chan fetchResult, len(ss.TargetSources))
+
+	groups := ss.sourceGroups()
+	queue := make(chan []types.Source, len(groups))
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+
+	workers := len(groups)
+	if ss.Concurrency > 0 && ss.Concurrency < workers {
+		workers = ss.Concurrency
+	}
+
 	var wg sync.WaitGroup
 
-	for _, source := range ss.TargetSources {
+	for range workers {
 		wg.Add(1)
-		go func(source types.Source) {
+		go func() {
 			defer wg.Done()
 
-			ss.Logger.Info(
-				logMsgGitClone, logKeyGitRepo, source.Alias,
-			)
-			source.Client.SetSource(&source)
+			for group := range queue {
+				for _, source := range group {
+					ss.Logger.Info(
+						logMsgGitClone, logKeyGitRepo, source.Alias,
+					)
+				}
 
-			bfs, err := source.Client.Clone(ctx)
-			if err != nil {
-				e := &package_errors.SourceError{
-					Message: fmt.Sprintf("inmem clone failed for %v", source.Alias),
-					Err:     err,
+				for _, result := range ss.fetchSources(ctx, group) {
+					results <- result
 				}
-				ss.Logger.Error(logMsgGitClone, logKeyGitRepo, source.Alias, logKeyErr, e.Err.Error())
-				errChan <- e
-				return
 			}
-
-			billyChan <- bfs
-		}(source)
+		}()
 	}
 
 	go func() {
 		wg.Wait()
-		close(billyChan)
-		close(errChan)
+		close(results)
 	}()
 
+	go ss.layer(results, billyChan, errChan)
+
 	return billyChan, errChan
 
 	// 	// for {
@@ -181,15 +362,369 @@ func (ss *SourceService) CloneSources(ctx context.Context) (chan billy.Filesyste
 	// // }
 }
 
-func (ss *SourceService) parseSourceSets() {
+/*
+layer sends each of results on billyChan, or errChan when it failed, in layer order. A result is held
+back until the results of every source before it have been sent. Both channels are closed once
+results is.
+*/
+func (ss *SourceService) layer(results <-chan fetchResult, billyChan chan<- SourceContent, errChan chan<- error) {
+	defer close(billyChan)
+	defer close(errChan)
+
+	order := ss.layerOrder()
+	held := make(map[string]fetchResult, len(order))
+	next := 0
+
+	for result := range results {
+		held[result.source.Alias] = result
+
+		for ; next < len(order); next++ {
+			r, ok := held[order[next]]
+			if !ok {
+				break
+			}
+			delete(held, order[next])
+
+			if r.err != nil {
+				e := &package_errors.SourceError{
+					Message: fmt.Sprintf("inmem clone failed for %v", r.source.Alias),
+					Err:     r.err,
+				}
+				ss.Logger.Error(logMsgGitClone, logKeyGitRepo, r.source.Alias, logKeyErr, e.Err.Error())
+				errChan <- e
+				continue
+			}
+
+			billyChan <- SourceContent{Source: r.source, Fs: r.bfs}
+		}
+	}
+}
+
+/*
+layerOrder returns the aliases of the target sources in the order they're layered: as listed in
+TargetSourceOrder, followed by any target source it doesn't list ordered by alias.
+*/
+func (ss *SourceService) layerOrder() []string {
+	order := make([]string, 0, len(ss.TargetSources))
+	listed := make(map[string]bool, len(ss.TargetSources))
+	for _, alias := range ss.TargetSourceOrder {
+		if _, ok := ss.TargetSources[alias]; ok && !listed[alias] {
+			order = append(order, alias)
+			listed[alias] = true
+		}
+	}
+
+	var unlisted []string
+	for alias := range ss.TargetSources {
+		if !listed[alias] {
+			unlisted = append(unlisted, alias)
+		}
+	}
+	sort.Strings(unlisted)
+
+	return append(order, unlisted...)
+}
+
+/*
+sourceGroups groups the target sources that can share one fetch: those whose client implements
+types.SourceFetcher and that have the same type, URL, ref and auth. Every other source is a group of
+its own. Sources within a group are ordered by alias.
+*/
+func (ss *SourceService) sourceGroups() [][]types.Source {
+	aliases := make([]string, 0, len(ss.TargetSources))
+	for alias := range ss.TargetSources {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	var groups [][]types.Source
+	shared := make(map[string]int)
+	for _, alias := range aliases {
+		source := ss.TargetSources[alias]
+		if _, ok := source.Client.(types.SourceFetcher); !ok {
+			groups = append(groups, []types.Source{source})
+			continue
+		}
+
+		key := strings.Join([]string{string(source.SourceType), source.URL, source.Ref, source.SourceAuthAlias}, "\x00")
+		if i, ok := shared[key]; ok {
+			groups[i] = append(groups[i], source)
+			continue
+		}
+		shared[key] = len(groups)
+		groups = append(groups, []types.Source{source})
+	}
+
+	return groups
+}
+
+// fetchResult is the content fetched for a source, or the error that stopped it.
+type fetchResult struct {
+	source types.Source
+	bfs    billy.Filesystem
+	err    error
+}
+
+/*
+fetchSources returns the content of every source in group, going through the cache when one is set.
+The group shares the first source's client, see sourceGroups. Offline, the cache is the only place
+content comes from. Otherwise sources whose client implements types.SourcePinner and are pinned are
+served from the cache at their pinned revision, and those whose client implements types.SourceResolver
+while their ref still resolves to a cached revision. Anything fetched is written back to the cache, where
+sources that can be neither pinned nor resolved are only read from offline. Refresh skips the lookup but
+still writes back. File sources are local already and are never cached.
+*/
+func (ss *SourceService) fetchSources(ctx context.Context, group []types.Source) []fetchResult {
+	results := make([]fetchResult, len(group))
+	for i, source := range group {
+		results[i].source = source
+	}
+
+	lead := group[0]
+	lead.Client.SetSource(&lead)
+	cached := ss.Cache != nil && lead.SourceType != types.FileSourceType
+
+	if cached && ss.Offline {
+		for i, source := range group {
+			results[i].bfs, results[i].err = ss.Cache.Latest(source.URL, source.Ref, source.Path)
+		}
+		return results
+	}
+
+	// Asked for ahead of the fetch, so the time taken to answer isn't counted against SourceTimeout.
+	if prompter, ok := lead.Client.(types.CredentialPrompter); ok {
+		if err := prompter.PromptCredentials(); err != nil {
+			for i := range results {
+				results[i].err = err
+			}
+			return results
+		}
+	}
+
+	pending := make([]int, 0, len(group))
+	for i := range group {
+		pending = append(pending, i)
+	}
+
+	resolvedRef := lead.Ref
+	lookup := false
+	if pinner, ok := lead.Client.(types.SourcePinner); ok && cached {
+		if revision, pinned := pinner.Pinned(); pinned {
+			resolvedRef, lookup = revision, true
+		}
+	}
+
+	if resolver, ok := lead.Client.(types.SourceResolver); ok && cached && !lookup {
+		err := ss.withRetry(ctx, lead.Alias, func(ctx context.Context) error {
+			var err error
+			resolvedRef, err = resolver.Resolve(ctx)
+			return err
+		})
+		if err != nil {
+			for i := range results {
+				results[i].err = err
+			}
+			return results
+		}
+		lookup = true
+	}
+
+	if lookup && !ss.Refresh {
+		pending = ss.fromCache(group, resolvedRef, results)
+	}
+
+	// The ref may have moved between resolving and fetching it, so the content is cached at the revision fetched.
+	if revision := ss.clone(ctx, group, pending, results); revision != "" {
+		resolvedRef = revision
+	}
+
+	if !cached {
+		return results
+	}
+
+	for _, i := range pending {
+		if results[i].err != nil {
+			continue
+		}
+		source := group[i]
+		_, err := ss.Cache.Put(storage.CacheEntry{
+			Alias:       source.Alias,
+			SourceType:  source.SourceType,
+			URL:         source.URL,
+			Ref:         source.Ref,
+			Path:        source.Path,
+			ResolvedRef: resolvedRef,
+		}, results[i].bfs)
+		if err != nil {
+			// A cache that can't be written shouldn't stop the source being rendered.
+			ss.Logger.Warn(logMsgCacheStore, logKeyGitRepo, source.Alias, logKeyErr, err.Error())
+		}
+	}
+
+	return results
+}
+
+// fromCache fills in the results of the sources in group cached at resolvedRef and returns the indexes of the rest.
+func (ss *SourceService) fromCache(group []types.Source, resolvedRef string, results []fetchResult) []int {
+	var pending []int
+	for i, source := range group {
+		bfs, err := ss.Cache.Get(source.URL, resolvedRef, source.Path)
+		if err == nil {
+			ss.Logger.Info(logMsgCacheHit, logKeyGitRepo, source.Alias, logKeyRef, resolvedRef)
+			results[i].bfs = bfs
+			continue
+		}
+
+		var missErr *storage.CacheMissError
+		if !errors.As(err, &missErr) {
+			ss.Logger.Warn(logMsgCacheHit, logKeyGitRepo, source.Alias, logKeyErr, err.Error())
+		}
+		pending = append(pending, i)
+	}
+
+	return pending
+}
+
+/*
+clone fetches the sources in group at the pending indexes into results. A client that implements
+types.SourceFetcher fetches once and each source's path is read from that fetch, any other client
+clones each source. It returns the revision a SourceFetcher fetched, empty for any other client.
+*/
+func (ss *SourceService) clone(ctx context.Context, group []types.Source, pending []int, results []fetchResult) string {
+	if len(pending) == 0 {
+		return ""
+	}
+
+	client := group[0].Client
+	fetcher, ok := client.(types.SourceFetcher)
+	if !ok {
+		for _, i := range pending {
+			source := group[i]
+			client.SetSource(&source)
+			results[i].err = ss.withRetry(ctx, source.Alias, func(ctx context.Context) error {
+				var err error
+				results[i].bfs, err = client.Clone(ctx)
+				return err
+			})
+		}
+		return ""
+	}
+
+	var snapshot types.SourceSnapshot
+	err := ss.withRetry(ctx, group[0].Alias, func(ctx context.Context) error {
+		var err error
+		snapshot, err = fetcher.Fetch(ctx)
+		return err
+	})
+	if err != nil {
+		for _, i := range pending {
+			results[i].err = err
+		}
+		return ""
+	}
+	defer snapshot.Close()
+
+	for _, i := range pending {
+		results[i].bfs, results[i].err = snapshot.Path(group[i].Path)
+	}
+	return snapshot.Revision()
+}
+
+// parseSourceSets maps the source sets by alias, expanding the sets each one extends, see expandSourceSet.
+func (ss *SourceService) parseSourceSets() error {
+	declared := make(map[string]types.SourceSet)
+	for _, sourceSet := range ss.SourceConfig.SourceSets {
+		declared[sourceSet.Alias] = sourceSet
+	}
+
 	ss.SourceSets = make(map[string]types.SourceSet)
 	for _, sourceSet := range ss.SourceConfig.SourceSets {
-		ss.SourceSets[sourceSet.Alias] = sourceSet
+		if _, err := expandSourceSet(declared, ss.SourceSets, sourceSet.Alias, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+expandSourceSet returns the declared source set alias with the sets it extends, in the order listed,
+expanded into it, and adds it to expanded. Their sources come before its own, each source kept only
+where it's first listed. Values and Targets are merged down the chain, a set's own overriding those it
+extends, and its own Conflicts are matched before theirs. chain holds the sets being expanded, to
+report a set that extends itself.
+*/
+func expandSourceSet(
+	declared, expanded map[string]types.SourceSet, alias string, chain []string,
+) (types.SourceSet, error) {
+	if sourceSet, ok := expanded[alias]; ok {
+		return sourceSet, nil
+	}
+	if at := slices.Index(chain, alias); at >= 0 {
+		return types.SourceSet{}, fmt.Errorf(
+			"source set %s extends itself: %s -> %s", alias, strings.Join(chain[at:], " -> "), alias,
+		)
+	}
+
+	sourceSet := declared[alias]
+	if len(sourceSet.Extends) == 0 {
+		expanded[alias] = sourceSet
+		return sourceSet, nil
+	}
+
+	chain = append(chain, alias)
+	var sources []string
+	var conflicts []types.ConflictRule
+	var onConflict types.ConflictStrategy
+	values := make(map[string]string)
+	targets := make(map[string]string)
+	for _, parentAlias := range sourceSet.Extends {
+		if _, ok := declared[parentAlias]; !ok {
+			return types.SourceSet{}, fmt.Errorf("source set %s extends unknown source set %s", alias, parentAlias)
+		}
+		parent, err := expandSourceSet(declared, expanded, parentAlias, chain)
+		if err != nil {
+			return types.SourceSet{}, err
+		}
+
+		sources = append(sources, parent.Sources...)
+		conflicts = append(slices.Clone(parent.Conflicts), conflicts...)
+		if parent.OnConflict != "" {
+			onConflict = parent.OnConflict
+		}
+		maps.Copy(values, parent.Values)
+		maps.Copy(targets, parent.Targets)
 	}
+
+	sourceSet.Sources = uniqueAliases(append(sources, sourceSet.Sources...))
+	sourceSet.Conflicts = append(slices.Clone(sourceSet.Conflicts), conflicts...)
+	if sourceSet.OnConflict == "" {
+		sourceSet.OnConflict = onConflict
+	}
+	maps.Copy(values, sourceSet.Values)
+	sourceSet.Values = values
+	maps.Copy(targets, sourceSet.Targets)
+	sourceSet.Targets = targets
+
+	expanded[alias] = sourceSet
+	return sourceSet, nil
+}
+
+// uniqueAliases returns aliases without repeats, each kept where it's first listed.
+func uniqueAliases(aliases []string) []string {
+	seen := make(map[string]bool, len(aliases))
+	unique := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if !seen[alias] {
+			seen[alias] = true
+			unique = append(unique, alias)
+		}
+	}
+	return unique
 }
 
 func (ss *SourceService) parseSources() {
 	ss.TargetSources = make(map[string]types.Source)
+	ss.TargetSourceOrder = nil
 	ss.SourceMap = make(map[string]types.Source)
 	for _, source := range ss.SourceConfig.Sources {
 		ss.SourceClients[string(source.SourceType)] = nil
@@ -200,21 +735,29 @@ func (ss *SourceService) parseSources() {
 // parseSourceAuths initializes the SourceAuthMap by iterating over the SourceAuths
 // defined in the SourceConfig. For each SourceAuth, it attempts to retrieve a
 // Personal Access Token (PAT) from the environment variables using a key formatted
-// as "TMLPTR_<AuthAlias>_PAT". If a PAT is found, it updates the corresponding
-// SourceAuth in the SourceAuthMap with the retrieved PAT.
+// as "TMLPTR_<AuthAlias>_PAT", and an SSH key passphrase using a key formatted as
+// "TMLPTR_<AuthAlias>_SSH_PASSPHRASE", with the / of a namespaced AuthAlias as _. Any value found
+// overrides the one on the corresponding SourceAuth in the SourceAuthMap. Auths from catalogs are
+// left as they are unless TrustCatalogSecrets is set.
 func (ss *SourceService) parseSourceAuths() {
 	ss.SourceAuthMap = make(map[string]types.SourceAuth)
 	for _, sourceAuth := range ss.SourceConfig.SourceAuths {
-		ss.SourceAuthMap[sourceAuth.AuthAlias] = sourceAuth
+		// A catalog could otherwise name its auth after a local one to be sent its PAT.
+		if sourceAuth.Untrusted && !ss.TrustCatalogSecrets {
+			ss.SourceAuthMap[sourceAuth.AuthAlias] = sourceAuth
+			continue
+		}
 
-		envVarString := fmt.Sprintf("TMLPTR_%s_PAT", sourceAuth.AuthAlias)
+		envAlias := strings.ReplaceAll(sourceAuth.AuthAlias, namespaceSeparator, "_")
+		if pat := os.Getenv(fmt.Sprintf("TMLPTR_%s_PAT", envAlias)); pat != "" {
+			sourceAuth.Pat = pat
+		}
 
-		pat := os.Getenv(envVarString)
-		if pat != "" {
-			auth := ss.SourceAuthMap[sourceAuth.AuthAlias]
-			auth.Pat = pat
-			ss.SourceAuthMap[sourceAuth.AuthAlias] = auth
+		if passphrase := os.Getenv(fmt.Sprintf("TMLPTR_%s_SSH_PASSPHRASE", envAlias)); passphrase != "" {
+			sourceAuth.SSHKeyPassphrase = passphrase
 		}
+
+		ss.SourceAuthMap[sourceAuth.AuthAlias] = sourceAuth
 	}
 }
 
@@ -224,6 +767,8 @@ func (ss *SourceService) parseSourceAuths() {
 // - GitSourceType: Initializes a Git client using storage.NewGitClient().
 // - FileSourceType: Initializes a File client using storage.NewFileClient().
 // - BlobSourceType: Initializes a Blob client using storage.NewBlobClient().
+// - ArchiveSourceType: Initializes an Archive client using storage.NewArchiveClient().
+// - OCISourceType: Initializes an OCI client using storage.NewOCIClient().
 func createSourceClients(t types.SourceType) (types.SourceCloner, error) {
 	switch t {
 	case types.GitSourceType:
@@ -232,45 +777,92 @@ func createSourceClients(t types.SourceType) (types.SourceCloner, error) {
 		return storage.NewFileClient(), nil
 	case types.BlobSourceType:
 		return storage.NewBlobClient(), nil
+	case types.ArchiveSourceType:
+		return storage.NewArchiveClient(), nil
+	case types.OCISourceType:
+		return storage.NewOCIClient(), nil
 	default:
 		return nil, errors.New("failed to create client for source")
 	}
 }
 
-// setTargetSources sets the target sources for a given alias by iterating through
-// the source aliases in the SourceSets map. It retrieves each source from the
-// SourceMap and adds it to the TargetSources map. It also inits the source client
+/*
+targetSourceAliases returns the aliases of the sources a given execution renders, in the order they're
+layered: Sources when given, otherwise those of SourceSet, followed by AddSources and without
+ExcludeSources.
+*/
+func (ss *SourceService) targetSourceAliases() ([]string, error) {
+	aliases := ss.Sources
+	if len(aliases) == 0 {
+		sourceSet, ok := ss.SourceSets[ss.SourceSet]
+		if !ok {
+			return nil, fmt.Errorf("source set not found: %s", ss.SourceSet)
+		}
+		aliases = sourceSet.Sources
+	}
+	aliases = append(slices.Clone(aliases), ss.AddSources...)
+
+	for _, excluded := range ss.ExcludeSources {
+		if !slices.Contains(aliases, excluded) {
+			return nil, fmt.Errorf("excluded source %s is not one of the sources to build", excluded)
+		}
+		aliases = slices.DeleteFunc(aliases, func(alias string) bool { return alias == excluded })
+	}
+
+	return aliases, nil
+}
+
+// setTargetSources sets the target sources from the given source aliases. It
+// retrieves each source from the SourceMap and adds it to the TargetSources map,
+// and to TargetSourceOrder in the order given. It also inits the source client
 // on the source If a source alias is not found in the SourceMap, an error is returned.
 //
 // Parameters:
-//   - alias: The key used to identify the set of sources in the SourceSets map.
+//   - aliases: The aliases, in the SourceMap, of the sources to target.
 //
 // Returns:
 //   - error: An error is returned if a source alias is not found in the SourceMap.
-func (ss *SourceService) setTargetSourcesFromSourceSet(alias string) error {
-	for _, sourceAlias := range ss.SourceSets[alias].Sources {
+func (ss *SourceService) setTargetSources(aliases []string) error {
+	for _, sourceAlias := range aliases {
 		source, ok := ss.SourceMap[sourceAlias]
 		if !ok {
 			return fmt.Errorf("source not found: %s", sourceAlias)
 		}
 		var err error
 		// Now we know we'll be using this source, initialize its client
-		source.Client, err = createSourceClients(source.SourceType)
+		source.Client, err = ss.NewClient(source.SourceType)
 		if err != nil {
 			return fmt.Errorf("%s: %w", sourceAlias, err)
 		}
+		if gc, ok := source.Client.(*storage.GitClient); ok {
+			gc.PassphrasePrompt = ss.PassphrasePrompt
+		}
+		if _, ok := ss.TargetSources[sourceAlias]; !ok {
+			ss.TargetSourceOrder = append(ss.TargetSourceOrder, sourceAlias)
+		}
 		ss.TargetSources[sourceAlias] = source
 	}
 	return nil
 }
 
+// setSourceAuthForSources sets the SourceAuth of every target source from its SourceAuthAlias. Secret
+// references in an auth are resolved the first time a target source uses it, so auths no target source
+// uses are never resolved.
 func (ss *SourceService) setSourceAuthForSources() error {
+	resolved := make(map[string]bool)
 	for _, source := range ss.TargetSources {
 		if source.SourceAuthAlias != "" {
 			sourceAuth, ok := ss.SourceAuthMap[source.SourceAuthAlias]
 			if !ok {
 				return fmt.Errorf("source auth not found: %s", source.SourceAuthAlias)
 			}
+			if !resolved[source.SourceAuthAlias] {
+				if err := ss.resolveSourceAuthSecrets(&sourceAuth); err != nil {
+					return err
+				}
+				ss.SourceAuthMap[source.SourceAuthAlias] = sourceAuth
+				resolved[source.SourceAuthAlias] = true
+			}
 			source.SourceAuth = &sourceAuth
 			ss.TargetSources[source.Alias] = source
 		}
@@ -3,10 +3,33 @@
 package services_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/OneFineDev/tmpltr/internal/services"
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	package_errors "github.com/OneFineDev/tmpltr/internal/tmpltrerrors"
 	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -260,3 +283,1279 @@ sources:
 		})
 	}
 }
+
+// fakeCloner serves a source whose remote is at revision, with version.txt holding the revision.
+type fakeCloner struct {
+	source   *types.Source
+	revision string
+	clones   int
+}
+
+func (f *fakeCloner) Clone(_ context.Context) (billy.Filesystem, error) {
+	f.clones++
+	mfs := memfs.New()
+	if err := util.WriteFile(mfs, "templates/version.txt", []byte(f.revision), 0644); err != nil {
+		return nil, err
+	}
+	return storage.ChrootSourcePath(mfs, f.source.Path)
+}
+
+func (f *fakeCloner) SetSource(s *types.Source) {
+	f.source = s
+}
+
+func (f *fakeCloner) Resolve(_ context.Context) (string, error) {
+	if f.revision == "" {
+		return "", errors.New("remote unreachable")
+	}
+	return f.revision, nil
+}
+
+func TestCloneSources_Cache(t *testing.T) {
+	// Arrange
+	cfg := &services.SourcesCommandConfig{CacheDir: t.TempDir()}
+	ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+	client := &fakeCloner{}
+
+	// The steps share the cache and run in order.
+	tests := []struct {
+		name            string
+		url             string
+		revision        string
+		offline         bool
+		refresh         bool
+		expectedVersion string
+		expectedClones  int
+		expectMiss      bool
+	}{
+		{
+			name:            "fetches and caches on first use",
+			revision:        "rev-1",
+			expectedVersion: "rev-1",
+			expectedClones:  1,
+		},
+		{
+			name:            "serves the cache while the ref resolves to the same revision",
+			revision:        "rev-1",
+			expectedVersion: "rev-1",
+			expectedClones:  1,
+		},
+		{
+			name:            "refresh fetches even when the cache is current",
+			revision:        "rev-1",
+			refresh:         true,
+			expectedVersion: "rev-1",
+			expectedClones:  2,
+		},
+		{
+			name:            "fetches when the ref resolves to a new revision",
+			revision:        "rev-2",
+			expectedVersion: "rev-2",
+			expectedClones:  3,
+		},
+		{
+			name:            "offline serves the latest cached revision without the remote",
+			offline:         true,
+			expectedVersion: "rev-2",
+			expectedClones:  3,
+		},
+		{
+			name:           "offline fails for a source that was never cached",
+			url:            "https://example.com/other.git",
+			offline:        true,
+			expectedClones: 3,
+			expectMiss:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			url := tt.url
+			if url == "" {
+				url = "https://example.com/templates.git"
+			}
+			client.revision = tt.revision
+			cfg.Offline = tt.offline
+			cfg.Refresh = tt.refresh
+			ss.TargetSources = map[string]types.Source{
+				"templates": {
+					Alias:      "templates",
+					SourceType: types.GitSourceType,
+					URL:        url,
+					Path:       "/templates",
+					Client:     client,
+				},
+			}
+
+			// Act
+			billyChan, errChan := ss.CloneSources(t.Context())
+
+			// Assert
+			var fetched []billy.Filesystem
+			for b := range billyChan {
+				fetched = append(fetched, b.Fs)
+			}
+			var errs []error
+			for e := range errChan {
+				errs = append(errs, e)
+			}
+			assert.Equal(t, tt.expectedClones, client.clones)
+
+			if tt.expectMiss {
+				require.Len(t, errs, 1)
+				var missErr *storage.CacheMissError
+				require.ErrorAs(t, errs[0], &missErr)
+				return
+			}
+			require.Empty(t, errs)
+			require.Len(t, fetched, 1)
+			content, err := util.ReadFile(fetched[0], "version.txt")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVersion, string(content))
+		})
+	}
+}
+
+// fakePinnedCloner serves an archive that can't be resolved, pinned to pinned when it's set.
+type fakePinnedCloner struct {
+	source *types.Source
+	pinned string
+	clones int
+}
+
+func (f *fakePinnedCloner) Clone(_ context.Context) (billy.Filesystem, error) {
+	f.clones++
+	return memfs.New(), nil
+}
+
+func (f *fakePinnedCloner) SetSource(s *types.Source) {
+	f.source = s
+}
+
+func (f *fakePinnedCloner) Pinned() (string, bool) {
+	return f.pinned, f.pinned != ""
+}
+
+func TestCloneSources_Pinned(t *testing.T) {
+	tests := []struct {
+		name           string
+		pinned         string
+		refresh        bool
+		expectedClones int
+	}{
+		{
+			name:           "serves a pinned source from the cache after the first fetch",
+			pinned:         "sha256:abc",
+			expectedClones: 1,
+		},
+		{
+			name:           "refresh fetches a pinned source again",
+			pinned:         "sha256:abc",
+			refresh:        true,
+			expectedClones: 3,
+		},
+		{
+			name:           "fetches an unpinned source on every run",
+			expectedClones: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			cfg := &services.SourcesCommandConfig{CacheDir: t.TempDir(), Refresh: tt.refresh}
+			ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+			client := &fakePinnedCloner{pinned: tt.pinned}
+
+			// Act
+			for range 3 {
+				ss.TargetSources = map[string]types.Source{
+					"templates": {
+						Alias:      "templates",
+						SourceType: types.ArchiveSourceType,
+						URL:        "https://example.com/templates.tar.gz",
+						Client:     client,
+					},
+				}
+				billyChan, errChan := ss.CloneSources(t.Context())
+				fetched := 0
+				for range billyChan {
+					fetched++
+				}
+				for e := range errChan {
+					require.NoError(t, e)
+				}
+				require.Equal(t, 1, fetched)
+			}
+
+			// Assert
+			assert.Equal(t, tt.expectedClones, client.clones)
+		})
+	}
+}
+
+/*
+fakeFetcher serves a repository holding a version.txt under each of paths, and counts its fetches. The
+fetch is at fetchedRevision when set, as if the ref moved after it was resolved.
+*/
+type fakeFetcher struct {
+	fakeCloner
+	paths           []string
+	fetchedRevision string
+	fetches         int
+}
+
+func (f *fakeFetcher) Fetch(_ context.Context) (types.SourceSnapshot, error) {
+	f.fetches++
+	revision := f.revision
+	if f.fetchedRevision != "" {
+		revision = f.fetchedRevision
+	}
+	mfs := memfs.New()
+	for _, p := range f.paths {
+		if err := util.WriteFile(mfs, path.Join(p, "version.txt"), []byte(revision+p), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return fakeSnapshot{fs: mfs, revision: revision}, nil
+}
+
+type fakeSnapshot struct {
+	fs       billy.Filesystem
+	revision string
+}
+
+func (s fakeSnapshot) Path(p string) (billy.Filesystem, error) {
+	return storage.ChrootSourcePath(s.fs, p)
+}
+
+func (s fakeSnapshot) Revision() string {
+	return s.revision
+}
+
+func (s fakeSnapshot) Close() error {
+	return nil
+}
+
+func TestCloneSources_SharedFetch(t *testing.T) {
+	// Arrange
+	cfg := &services.SourcesCommandConfig{CacheDir: t.TempDir()}
+	ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+	templatePaths := []string{"/terraform", "/go-web", "/docs"}
+	sources := map[string]struct{ url, path string }{
+		"terraform": {"https://example.com/templates.git", "/terraform"},
+		"goWeb":     {"https://example.com/templates.git", "/go-web"},
+		"docs":      {"https://example.com/templates.git", "/docs"},
+		"ansible":   {"https://example.com/templates.git", "/ansible"},
+		"other":     {"https://example.com/other.git", "/"},
+	}
+
+	// The steps share the cache and run in order.
+	tests := []struct {
+		name                     string
+		aliases                  []string
+		expectedTemplatesFetches int
+		expectedOtherFetches     int
+		expectedVersions         []string
+		expectedPathErrs         []string
+	}{
+		{
+			name:                     "fetches each repository once",
+			aliases:                  []string{"terraform", "goWeb", "ansible", "other"},
+			expectedTemplatesFetches: 1,
+			expectedOtherFetches:     1,
+			expectedVersions:         []string{"rev-1/terraform", "rev-1/go-web", "rev-1/"},
+			expectedPathErrs:         []string{"/ansible"},
+		},
+		{
+			name:             "serves every path from the cache",
+			aliases:          []string{"terraform", "goWeb", "other"},
+			expectedVersions: []string{"rev-1/terraform", "rev-1/go-web", "rev-1/"},
+		},
+		{
+			name:                     "fetches once for the paths missing from the cache",
+			aliases:                  []string{"terraform", "docs"},
+			expectedTemplatesFetches: 1,
+			expectedVersions:         []string{"rev-1/terraform", "rev-1/docs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			clients := make(map[string]*fakeFetcher)
+			ss.TargetSources = make(map[string]types.Source)
+			for _, alias := range tt.aliases {
+				paths := templatePaths
+				if alias == "other" {
+					paths = []string{"/"}
+				}
+				clients[alias] = &fakeFetcher{fakeCloner: fakeCloner{revision: "rev-1"}, paths: paths}
+				ss.TargetSources[alias] = types.Source{
+					Alias:      alias,
+					SourceType: types.GitSourceType,
+					URL:        sources[alias].url,
+					Ref:        "main",
+					Path:       sources[alias].path,
+					Client:     clients[alias],
+				}
+			}
+
+			// Act
+			billyChan, errChan := ss.CloneSources(t.Context())
+
+			// Assert
+			var versions []string
+			for b := range billyChan {
+				content, err := util.ReadFile(b.Fs, "version.txt")
+				require.NoError(t, err)
+				versions = append(versions, string(content))
+			}
+			var pathErrs []string
+			for e := range errChan {
+				var pathErr *storage.SourcePathError
+				require.ErrorAs(t, e, &pathErr)
+				pathErrs = append(pathErrs, pathErr.SourcePath)
+			}
+
+			templatesFetches, otherFetches := 0, 0
+			for alias, client := range clients {
+				if alias == "other" {
+					otherFetches += client.fetches
+					continue
+				}
+				templatesFetches += client.fetches
+			}
+			assert.Equal(t, tt.expectedTemplatesFetches, templatesFetches)
+			assert.Equal(t, tt.expectedOtherFetches, otherFetches)
+			assert.ElementsMatch(t, tt.expectedVersions, versions)
+			assert.ElementsMatch(t, tt.expectedPathErrs, pathErrs)
+		})
+	}
+}
+
+func TestCloneSources_MovedRef(t *testing.T) {
+	// Arrange
+	cfg := &services.SourcesCommandConfig{CacheDir: t.TempDir()}
+	ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+	client := &fakeFetcher{fakeCloner: fakeCloner{revision: "rev-1"}, paths: []string{"/"}, fetchedRevision: "rev-2"}
+	ss.TargetSources = map[string]types.Source{
+		"templates": {
+			Alias:      "templates",
+			SourceType: types.GitSourceType,
+			URL:        "https://example.com/templates.git",
+			Ref:        "main",
+			Path:       "/",
+			Client:     client,
+		},
+	}
+
+	// Act
+	billyChan, errChan := ss.CloneSources(t.Context())
+
+	// Assert
+	fetched := 0
+	for range billyChan {
+		fetched++
+	}
+	for e := range errChan {
+		require.NoError(t, e)
+	}
+	assert.Equal(t, 1, fetched)
+
+	entries, err := ss.Cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "rev-2", entries[0].ResolvedRef)
+
+	_, err = ss.Cache.Get("https://example.com/templates.git", "rev-1", "/")
+	var missErr *storage.CacheMissError
+	require.ErrorAs(t, err, &missErr)
+}
+
+// concurrentCloner records the most clones any of its copies had in flight at once.
+type concurrentCloner struct {
+	mu       *sync.Mutex
+	inFlight *int
+	maxSeen  *int
+}
+
+func (c concurrentCloner) Clone(_ context.Context) (billy.Filesystem, error) {
+	c.mu.Lock()
+	*c.inFlight++
+	*c.maxSeen = max(*c.maxSeen, *c.inFlight)
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mu.Lock()
+	*c.inFlight--
+	c.mu.Unlock()
+	return memfs.New(), nil
+}
+
+func (c concurrentCloner) SetSource(_ *types.Source) {}
+
+func TestCloneSources_Concurrency(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name        string
+		concurrency int
+		sources     int
+		expectedMax int
+	}{
+		{
+			name:        "bounds the fetches in flight",
+			concurrency: 2,
+			sources:     6,
+			expectedMax: 2,
+		},
+		{
+			name:        "fetches every source at once when unbounded",
+			concurrency: 0,
+			sources:     4,
+			expectedMax: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &services.SourcesCommandConfig{Concurrency: tt.concurrency}
+			ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+			client := concurrentCloner{mu: &sync.Mutex{}, inFlight: new(int), maxSeen: new(int)}
+			ss.TargetSources = make(map[string]types.Source)
+			for i := range tt.sources {
+				alias := fmt.Sprintf("source%d", i)
+				ss.TargetSources[alias] = types.Source{Alias: alias, SourceType: types.FileSourceType, Client: client}
+			}
+
+			// Act
+			billyChan, errChan := ss.CloneSources(t.Context())
+
+			// Assert
+			fetched := 0
+			for range billyChan {
+				fetched++
+			}
+			for e := range errChan {
+				require.NoError(t, e)
+			}
+			assert.Equal(t, tt.sources, fetched)
+			assert.LessOrEqual(t, *client.maxSeen, tt.expectedMax)
+			assert.Positive(t, *client.maxSeen)
+		})
+	}
+}
+
+// delayedCloner serves a Makefile holding its content, or fails with err, after delay.
+type delayedCloner struct {
+	delay   time.Duration
+	content string
+	err     error
+}
+
+func (c delayedCloner) Clone(_ context.Context) (billy.Filesystem, error) {
+	time.Sleep(c.delay)
+	if c.err != nil {
+		return nil, c.err
+	}
+	mfs := memfs.New()
+	if err := util.WriteFile(mfs, "Makefile", []byte(c.content), 0644); err != nil {
+		return nil, err
+	}
+	return mfs, nil
+}
+
+func (c delayedCloner) SetSource(_ *types.Source) {}
+
+func TestCloneSources_LayerOrder(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name             string
+		order            []string
+		clients          map[string]delayedCloner
+		expectedContents []string
+		expectedFailed   []string
+		expectedMakefile string
+	}{
+		{
+			name:  "layers in the listed order whichever fetch finishes first",
+			order: []string{"common", "goTooling", "project"},
+			clients: map[string]delayedCloner{
+				"common":    {delay: 40 * time.Millisecond, content: "common"},
+				"goTooling": {delay: 20 * time.Millisecond, content: "goTooling"},
+				"project":   {content: "project"},
+			},
+			expectedContents: []string{"common", "goTooling", "project"},
+			expectedMakefile: "project",
+		},
+		{
+			name:  "a failed source keeps the later sources waiting on it in order",
+			order: []string{"project", "common", "goTooling"},
+			clients: map[string]delayedCloner{
+				"project":   {delay: 20 * time.Millisecond, content: "project"},
+				"common":    {delay: 40 * time.Millisecond, err: errors.New("remote unreachable")},
+				"goTooling": {content: "goTooling"},
+			},
+			expectedContents: []string{"project", "goTooling"},
+			expectedFailed:   []string{"common"},
+			expectedMakefile: "goTooling",
+		},
+		{
+			name:  "sources missing from the order come last by alias",
+			order: []string{"project"},
+			clients: map[string]delayedCloner{
+				"project":   {delay: 20 * time.Millisecond, content: "project"},
+				"goTooling": {content: "goTooling"},
+				"common":    {delay: 10 * time.Millisecond, content: "common"},
+			},
+			expectedContents: []string{"project", "common", "goTooling"},
+			expectedMakefile: "goTooling",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := services.NewSourceService(&services.SourcesCommandConfig{}, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+			ss.TargetSources = make(map[string]types.Source)
+			for alias, client := range tt.clients {
+				ss.TargetSources[alias] = types.Source{Alias: alias, SourceType: types.FileSourceType, Client: client}
+			}
+			ss.TargetSourceOrder = tt.order
+			safeFs := &storage.SafeFs{Fs: afero.NewMemMapFs()}
+
+			// Act
+			billyChan, errChan := ss.CloneSources(t.Context())
+
+			// Assert
+			var contents []string
+			for b := range billyChan {
+				content, err := util.ReadFile(b.Fs, "Makefile")
+				require.NoError(t, err)
+				contents = append(contents, string(content))
+				require.NoError(t, safeFs.CopySourceSafe(b.Source.Alias, b.Fs, "/", "/out"))
+			}
+			var failed []string
+			for e := range errChan {
+				var sourceErr *package_errors.SourceError
+				require.ErrorAs(t, e, &sourceErr)
+				failed = append(failed, strings.TrimPrefix(sourceErr.Message, "inmem clone failed for "))
+			}
+			assert.Equal(t, tt.expectedContents, contents)
+			assert.Equal(t, tt.expectedFailed, failed)
+
+			makefile, err := afero.ReadFile(safeFs.Fs, "/out/Makefile")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedMakefile, string(makefile))
+		})
+	}
+}
+
+func TestBuildProjectSourceConfigs_Secrets(t *testing.T) {
+	// Arrange
+	secretFile := filepath.Join(t.TempDir(), "pat")
+	require.NoError(t, os.WriteFile(secretFile, []byte("file-pat\n"), 0600))
+	t.Setenv("TMPLTR_TEST_TOKEN", "env-token")
+
+	tests := []struct {
+		name             string
+		sourceAuth       types.SourceAuth
+		expectedAuth     types.SourceAuth
+		expectedErrAlias string
+	}{
+		{
+			name:         "env reference",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", Token: "env:TMPLTR_TEST_TOKEN"},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", Token: "env-token"},
+		},
+		{
+			name:         "file reference without trailing newline",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", Pat: "file:" + secretFile},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", Pat: "file-pat"},
+		},
+		{
+			name:         "cmd reference",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", Key: "cmd:echo cmd-key"},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", Key: "cmd-key"},
+		},
+		{
+			name:         "keyring reference through a registered resolver",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", SSHKey: "keyring:github-deploy-key"},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", SSHKey: "/keys/github-deploy-key"},
+		},
+		{
+			name:         "plain values are kept",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", Pat: "ghp_plain", SSHKey: "~/.ssh/id_ed25519"},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", Pat: "ghp_plain", SSHKey: "~/.ssh/id_ed25519"},
+		},
+		{
+			name:         "unknown schemes are kept",
+			sourceAuth:   types.SourceAuth{AuthAlias: "github", Token: "vault:secret/github"},
+			expectedAuth: types.SourceAuth{AuthAlias: "github", Token: "vault:secret/github"},
+		},
+		{
+			name:             "unset env variable names the auth alias",
+			sourceAuth:       types.SourceAuth{AuthAlias: "ado", Pat: "env:TMPLTR_TEST_UNSET"},
+			expectedErrAlias: "ado",
+		},
+		{
+			name:             "failing command names the auth alias",
+			sourceAuth:       types.SourceAuth{AuthAlias: "ado", Pat: "cmd:exit 1"},
+			expectedErrAlias: "ado",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			ss := services.NewSourceService(&services.SourcesCommandConfig{SourceSet: "set"},
+				slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+			ss.SecretResolvers[services.SecretSchemeKeyring] = services.SecretResolverFunc(
+				func(ref string) (string, error) { return "/keys/" + ref, nil },
+			)
+			srcConfig := &types.SourceConfig{
+				SourceAuths: types.SourceAuths{tt.sourceAuth},
+				Sources: types.Sources{{
+					Alias:           "templates",
+					SourceType:      types.GitSourceType,
+					URL:             "https://github.com/onefinedev/templates.git",
+					SourceAuthAlias: tt.sourceAuth.AuthAlias,
+				}},
+				SourceSets: types.SourceSets{{Alias: "set", Sources: []string{"templates"}}},
+			}
+
+			// Act
+			err := ss.BuildProjectSourceConfigs(srcConfig)
+
+			// Assert
+			if tt.expectedErrAlias != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "source auth "+tt.expectedErrAlias)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedAuth, *ss.TargetSources["templates"].SourceAuth)
+		})
+	}
+}
+
+func TestSourceTargets(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name            string
+		projectName     string
+		valuesFile      string
+		sources         map[string]string
+		setTargets      map[string]string
+		expectedTargets map[string]string
+		expectedError   string
+	}{
+		{
+			name:            "sources without a target render at the root",
+			sources:         map[string]string{"common": ""},
+			expectedTargets: map[string]string{"common": ""},
+		},
+		{
+			name:            "sources render at their own target",
+			sources:         map[string]string{"doc": "docs", "vscode": "/.vscode/"},
+			expectedTargets: map[string]string{"doc": "docs", "vscode": ".vscode"},
+		},
+		{
+			name:            "the source set's targets replace the sources' own",
+			sources:         map[string]string{"doc": "docs", "vscode": ".vscode"},
+			setTargets:      map[string]string{"doc": "documentation/site", "vscode": ""},
+			expectedTargets: map[string]string{"doc": "documentation/site", "vscode": ""},
+		},
+		{
+			name:            "targets are rendered with the project name",
+			projectName:     "billing",
+			sources:         map[string]string{"service": "cmd/{{.projectName}}"},
+			expectedTargets: map[string]string{"service": "cmd/billing"},
+		},
+		{
+			name:            "targets are rendered with the values file",
+			projectName:     "billing",
+			valuesFile:      "module: payments\nprojectName: ignored\n",
+			sources:         map[string]string{"service": "{{.module}}/{{.projectName}}"},
+			expectedTargets: map[string]string{"service": "payments/billing"},
+		},
+		{
+			name:            "targets can't climb out of the project",
+			sources:         map[string]string{"doc": "../../etc"},
+			expectedTargets: map[string]string{"doc": "etc"},
+		},
+		{
+			name:          "fails on a missing value",
+			sources:       map[string]string{"service": "cmd/{{.projectName}}"},
+			expectedError: `failed to render target "cmd/{{.projectName}}" for source service`,
+		},
+		{
+			name:          "fails on an invalid template",
+			sources:       map[string]string{"service": "cmd/{{.projectName"},
+			expectedError: `invalid target "cmd/{{.projectName" for source service`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &services.SourcesCommandConfig{ProjectName: tt.projectName, SourceSet: "set"}
+			if tt.valuesFile != "" {
+				cfg.ValuesFilePath = filepath.Join(t.TempDir(), "values.yaml")
+				require.NoError(t, os.WriteFile(cfg.ValuesFilePath, []byte(tt.valuesFile), 0600))
+			}
+			ss := services.NewSourceService(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+			ss.SourceSets = map[string]types.SourceSet{"set": {Alias: "set", Targets: tt.setTargets}}
+			ss.TargetSources = make(map[string]types.Source)
+			for alias, target := range tt.sources {
+				ss.TargetSources[alias] = types.Source{Alias: alias, Target: target}
+			}
+
+			// Act
+			values, err := ss.TargetValues()
+			require.NoError(t, err)
+			targets, err := ss.SourceTargets(values)
+
+			// Assert
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedTargets, targets)
+		})
+	}
+}
+
+func TestBuildProjectSourceConfigs_TargetSources(t *testing.T) {
+	// Arrange
+	srcConfig := &types.SourceConfig{
+		Sources: types.Sources{
+			{Alias: "goWeb", SourceType: types.FileSourceType, Path: "/templates/go-web"},
+			{Alias: "goTooling", SourceType: types.FileSourceType, Path: "/templates/go-tooling"},
+			{Alias: "vscode", SourceType: types.FileSourceType, Path: "/templates/vscode"},
+			{Alias: "common", SourceType: types.FileSourceType, Path: "/templates/common"},
+			{Alias: "doc", SourceType: types.FileSourceType, Path: "/templates/doc"},
+		},
+		SourceSets: types.SourceSets{
+			{Alias: "goWebSet", Sources: []string{"goWeb", "goTooling", "common"}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		cfg           services.SourcesCommandConfig
+		expectedOrder []string
+		expectedError string
+	}{
+		{
+			name:          "sources of the source set",
+			cfg:           services.SourcesCommandConfig{SourceSet: "goWebSet"},
+			expectedOrder: []string{"goWeb", "goTooling", "common"},
+		},
+		{
+			name:          "ad-hoc sources",
+			cfg:           services.SourcesCommandConfig{Sources: []string{"doc", "vscode"}},
+			expectedOrder: []string{"doc", "vscode"},
+		},
+		{
+			name: "sources added to and excluded from the source set",
+			cfg: services.SourcesCommandConfig{
+				SourceSet:      "goWebSet",
+				AddSources:     []string{"vscode", "doc"},
+				ExcludeSources: []string{"goTooling"},
+			},
+			expectedOrder: []string{"goWeb", "common", "vscode", "doc"},
+		},
+		{
+			name: "a source added twice is layered where first listed",
+			cfg: services.SourcesCommandConfig{
+				SourceSet:  "goWebSet",
+				AddSources: []string{"goWeb"},
+			},
+			expectedOrder: []string{"goWeb", "goTooling", "common"},
+		},
+		{
+			name:          "unknown source set",
+			cfg:           services.SourcesCommandConfig{SourceSet: "goServiceSet"},
+			expectedError: "source set not found: goServiceSet",
+		},
+		{
+			name:          "unknown added source",
+			cfg:           services.SourcesCommandConfig{SourceSet: "goWebSet", AddSources: []string{"terraform"}},
+			expectedError: "source not found: terraform",
+		},
+		{
+			name:          "excluded source not in the source set",
+			cfg:           services.SourcesCommandConfig{SourceSet: "goWebSet", ExcludeSources: []string{"doc"}},
+			expectedError: "excluded source doc is not one of the sources to build",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := services.NewSourceService(&tt.cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+
+			// Act
+			err := ss.BuildProjectSourceConfigs(srcConfig)
+
+			// Assert
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOrder, ss.TargetSourceOrder)
+			assert.Len(t, ss.TargetSources, len(tt.expectedOrder))
+			for _, alias := range tt.expectedOrder {
+				assert.NotNil(t, ss.TargetSources[alias].Client, alias)
+			}
+		})
+	}
+}
+
+func TestLoadSourceConfig(t *testing.T) {
+	// Arrange
+	platformCatalog := `namespace: platform
+sourceAuths:
+  - authAlias: ado
+    sshKeyPath: /keys/ado
+sources:
+  - alias: goWeb
+    sourceType: git
+    url: git@example.com:platform/go-web
+    sourceAuthAlias: ado
+  - alias: common
+    sourceType: git
+    url: git@example.com:platform/common
+    sourceAuthAlias: ado
+sourceSets:
+  - alias: baseGoSet
+    sources:
+      - common
+  - alias: goWebSet
+    extends:
+      - baseGoSet
+    sources:
+      - goWeb
+    targets:
+      goWeb: web
+`
+	teamSources := `sources:
+  - alias: goWeb
+    sourceType: file
+    path: /templates/go-web
+sourceSets:
+  - alias: teamWebSet
+    extends:
+      - platform/goWebSet
+    sources:
+      - goWeb
+`
+	duplicateSources := `sources:
+  - alias: common
+    sourceType: file
+    path: /templates/common
+
+  - alias: goWeb
+    sourceType: file
+    path: /templates/go-web-fork
+`
+
+	tests := []struct {
+		name            string
+		locations       []string
+		expectedSources []string
+		expectedSets    map[string][]string
+		expectedAuths   map[string]string
+		expectedTargets map[string]string
+		expectedErrors  []string
+	}{
+		{
+			name:            "namespaced catalog and team sources",
+			locations:       []string{"/catalog/sources.yaml", "/team/sources.yaml"},
+			expectedSources: []string{"platform/goWeb", "platform/common", "goWeb"},
+			expectedSets: map[string][]string{
+				"platform/baseGoSet": {"platform/common"},
+				"platform/goWebSet":  {"platform/goWeb"},
+				"teamWebSet":         {"goWeb"},
+			},
+			expectedAuths:   map[string]string{"platform/goWeb": "platform/ado", "platform/common": "platform/ado"},
+			expectedTargets: map[string]string{"platform/goWeb": "web"},
+		},
+		{
+			name:      "duplicate aliases",
+			locations: []string{"/team/sources.yaml", "/team/duplicate.yaml"},
+			expectedErrors: []string{
+				"duplicate source alias goWeb: defined at /team/sources.yaml:2 and /team/duplicate.yaml:6",
+			},
+		},
+		{
+			name:           "missing file",
+			locations:      []string{"/catalog/sources.yaml", "/team/missing.yaml"},
+			expectedErrors: []string{"/team/missing.yaml: "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/catalog/sources.yaml", []byte(platformCatalog), 0o644))
+			require.NoError(t, afero.WriteFile(fs, "/team/sources.yaml", []byte(teamSources), 0o644))
+			require.NoError(t, afero.WriteFile(fs, "/team/duplicate.yaml", []byte(duplicateSources), 0o644))
+
+			ss := services.NewSourceService(
+				&services.SourcesCommandConfig{}, slog.New(slog.NewTextHandler(io.Discard, nil)), "test",
+			)
+
+			// Act
+			cfg, err := ss.LoadSourceConfig(context.Background(), fs, tt.locations)
+
+			// Assert
+			if len(tt.expectedErrors) > 0 {
+				require.Error(t, err)
+				for _, expected := range tt.expectedErrors {
+					assert.Contains(t, err.Error(), expected)
+				}
+				return
+			}
+			require.NoError(t, err)
+
+			aliases := make([]string, 0, len(cfg.Sources))
+			for _, source := range cfg.Sources {
+				aliases = append(aliases, source.Alias)
+				if auth, ok := tt.expectedAuths[source.Alias]; ok {
+					assert.Equal(t, auth, source.SourceAuthAlias, source.Alias)
+				}
+			}
+			assert.Equal(t, tt.expectedSources, aliases)
+			assert.Equal(t, "platform/ado", cfg.SourceAuths[0].AuthAlias)
+
+			sets := make(map[string][]string, len(cfg.SourceSets))
+			for _, sourceSet := range cfg.SourceSets {
+				sets[sourceSet.Alias] = sourceSet.Sources
+			}
+			assert.Equal(t, tt.expectedSets, sets)
+			assert.Equal(t, tt.expectedTargets, cfg.SourceSets[1].Targets)
+			assert.Equal(t, []string{"platform/baseGoSet"}, cfg.SourceSets[1].Extends)
+			assert.Equal(t, []string{"platform/goWebSet"}, cfg.SourceSets[2].Extends)
+		})
+	}
+}
+
+func TestLoadSourceConfig_Catalog(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the catalog repository over the file transport")
+	}
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(catalog string) plumbing.Hash {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "tmpltr"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tmpltr/sources.yaml"), []byte(catalog), 0o644))
+		_, err = wt.Add("tmpltr/sources.yaml")
+		require.NoError(t, err)
+		hash, err := wt.Commit("catalog", &git.CommitOptions{
+			Author: &object.Signature{Name: "Test User", Email: "test@example.com"},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+
+	released := commit(`namespace: platform
+sources:
+  - alias: goWeb
+    sourceType: git
+    url: git@example.com:platform/go-web
+`)
+	_, err = repo.CreateTag("v1", released, nil)
+	require.NoError(t, err)
+	commit(`namespace: platform
+sources:
+  - alias: goWeb
+    sourceType: git
+    url: git@example.com:platform/go-web
+  - alias: goService
+    sourceType: git
+    url: git@example.com:platform/go-service
+`)
+
+	catalogURL := "file://" + dir
+	tests := []struct {
+		name            string
+		location        string
+		catalogRef      string
+		expectedSources []string
+	}{
+		{
+			name:            "catalog at the remote HEAD",
+			location:        catalogURL + "//tmpltr/sources.yaml",
+			expectedSources: []string{"platform/goWeb", "platform/goService"},
+		},
+		{
+			name:            "catalog at its ref",
+			location:        catalogURL + "//tmpltr/sources.yaml?ref=v1",
+			expectedSources: []string{"platform/goWeb"},
+		},
+		{
+			name:            "catalog pinned by catalog ref",
+			location:        catalogURL + "//tmpltr/sources.yaml",
+			catalogRef:      "v1",
+			expectedSources: []string{"platform/goWeb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := services.SourcesCommandConfig{CacheDir: t.TempDir(), CatalogRef: tt.catalogRef}
+			ss := services.NewSourceService(&cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), "test")
+
+			// Act
+			fetched, fetchErr := ss.LoadSourceConfig(context.Background(), afero.NewMemMapFs(), []string{tt.location})
+			cfg.Offline = true
+			cached, cacheErr := ss.LoadSourceConfig(context.Background(), afero.NewMemMapFs(), []string{tt.location})
+
+			// Assert
+			require.NoError(t, fetchErr)
+			require.NoError(t, cacheErr)
+			for _, loaded := range []*types.SourceConfig{fetched, cached} {
+				aliases := make([]string, 0, len(loaded.Sources))
+				for _, source := range loaded.Sources {
+					aliases = append(aliases, source.Alias)
+				}
+				assert.Equal(t, tt.expectedSources, aliases)
+			}
+		})
+	}
+}
+
+func TestBuildProjectSourceConfigs_CatalogSecrets(t *testing.T) {
+	// Arrange
+	t.Setenv("TMPLTR_TEST_TOKEN", "env-token")
+
+	tests := []struct {
+		name                string
+		pat                 string
+		patOverride         string
+		trustCatalogSecrets bool
+		expectedErr         string
+		expectedPat         string
+		expectedRan         bool
+	}{
+		{
+			name:        "cmd reference is refused",
+			pat:         "cmd:touch %s",
+			expectedErr: "cmd references aren't allowed in auths from a catalog",
+		},
+		{
+			name:        "file reference is refused",
+			pat:         "file:~/.ssh/id_rsa",
+			expectedErr: "file references aren't allowed in auths from a catalog",
+		},
+		{
+			name:        "keyring reference is refused",
+			pat:         "keyring:github",
+			expectedErr: "keyring references aren't allowed in auths from a catalog",
+		},
+		{
+			name:        "env reference is refused",
+			pat:         "env:TMPLTR_TEST_TOKEN",
+			expectedErr: "env references aren't allowed in auths from a catalog",
+		},
+		{
+			name:        "env PAT override is ignored",
+			pat:         "catalog-pat",
+			patOverride: "local-pat",
+			expectedPat: "catalog-pat",
+		},
+		{
+			name:                "env reference is resolved when catalog secrets are trusted",
+			pat:                 "env:TMPLTR_TEST_TOKEN",
+			trustCatalogSecrets: true,
+			expectedPat:         "env-token",
+		},
+		{
+			name:                "env PAT override applies when catalog secrets are trusted",
+			pat:                 "catalog-pat",
+			patOverride:         "local-pat",
+			trustCatalogSecrets: true,
+			expectedPat:         "local-pat",
+		},
+		{
+			name:                "cmd reference is resolved when catalog secrets are trusted",
+			pat:                 "cmd:touch %s && echo cmd-pat",
+			trustCatalogSecrets: true,
+			expectedPat:         "cmd-pat",
+			expectedRan:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marker := filepath.Join(t.TempDir(), "ran")
+			t.Setenv("TMLPTR_platform_ado_PAT", tt.patOverride)
+			pat := tt.pat
+			if strings.Contains(pat, "%s") {
+				pat = fmt.Sprintf(pat, marker)
+			}
+
+			catalog := fmt.Sprintf(`namespace: platform
+sourceAuths:
+  - authAlias: ado
+    pat: %q
+sources:
+  - alias: goWeb
+    sourceType: git
+    url: https://example.com/platform/go-web.git
+    sourceAuthAlias: ado
+sourceSets:
+  - alias: goWebSet
+    sources:
+      - goWeb
+`, pat)
+			srcConfig, err := services.MergeSourceConfigs([]services.SourceConfigFile{
+				{Location: "https://example.com/catalog.git//sources.yaml", Data: []byte(catalog), Untrusted: true},
+			})
+			require.NoError(t, err)
+
+			ss := services.NewSourceService(
+				&services.SourcesCommandConfig{SourceSet: "platform/goWebSet", TrustCatalogSecrets: tt.trustCatalogSecrets},
+				slog.New(slog.NewTextHandler(io.Discard, nil)), "test",
+			)
+
+			// Act
+			err = ss.BuildProjectSourceConfigs(srcConfig)
+
+			// Assert
+			_, statErr := os.Stat(marker)
+			assert.Equal(t, tt.expectedRan, statErr == nil, "whether the command ran")
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPat, ss.TargetSources["platform/goWeb"].SourceAuth.Pat)
+		})
+	}
+}
+
+func TestLoadSourceConfig_CatalogAuth(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the catalog repository")
+	}
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sources.yaml"), []byte(`namespace: platform
+sources:
+  - alias: goWeb
+    sourceType: git
+    url: git@example.com:platform/go-web
+`), 0o644))
+	_, err = wt.Add("sources.yaml")
+	require.NoError(t, err)
+	_, err = wt.Commit("catalog", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test User", Email: "test@example.com"},
+	})
+	require.NoError(t, err)
+
+	root := t.TempDir()
+	for _, name := range []string{"public/catalog.git", "private/catalog.git"} {
+		_, err = git.PlainClone(filepath.Join(root, name), true, &git.CloneOptions{URL: dir})
+		require.NoError(t, err)
+	}
+
+	out, err := exec.Command("git", "--exec-path").Output()
+	require.NoError(t, err)
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/private/") {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "s3cret" {
+				w.Header().Set("Www-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("TMPLTR_TEST_CATALOG_PAT", "s3cret")
+
+	privateURL := server.URL + "/private/catalog.git"
+	tests := []struct {
+		name         string
+		url          string
+		catalogAuths map[string]services.CatalogAuth
+		storedURL    string
+		expectErr    bool
+	}{
+		{
+			name: "private catalog with its auth",
+			url:  privateURL,
+			catalogAuths: map[string]services.CatalogAuth{
+				privateURL: {SourceAuth: types.SourceAuth{UserName: "ci", Pat: "env:TMPLTR_TEST_CATALOG_PAT"}},
+			},
+		},
+		{
+			name:      "private catalog with git's credentials",
+			url:       privateURL,
+			storedURL: strings.Replace(server.URL, "http://", "http://ci:s3cret@", 1),
+		},
+		{
+			name: "public catalog without credentials",
+			url:  server.URL + "/public/catalog.git",
+		},
+		{
+			name:      "private catalog without credentials",
+			url:       privateURL,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Give git credential fill a credential store holding only the case's credentials.
+			gitConfigDir := t.TempDir()
+			credentials := filepath.Join(gitConfigDir, "credentials")
+			require.NoError(t, os.WriteFile(credentials, []byte(tt.storedURL+"\n"), 0o600))
+			gitConfig := filepath.Join(gitConfigDir, "gitconfig")
+			require.NoError(t, os.WriteFile(gitConfig,
+				[]byte("[credential]\n\thelper = store --file="+credentials+"\n"), 0o600))
+			t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+
+			ss := services.NewSourceService(
+				&services.SourcesCommandConfig{CatalogAuths: tt.catalogAuths},
+				slog.New(slog.NewTextHandler(io.Discard, nil)), "test",
+			)
+
+			// Act
+			cfg, err := ss.LoadSourceConfig(context.Background(), afero.NewMemMapFs(), []string{tt.url + "//sources.yaml"})
+
+			// Assert
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, cfg.Sources, 1)
+			assert.Equal(t, "platform/goWeb", cfg.Sources[0].Alias)
+		})
+	}
+}
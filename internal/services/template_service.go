@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 	"text/template/parse"
@@ -198,13 +199,137 @@ func (ts *TemplateService) CreateTemplateValuesMap() {
 	}
 }
 
+// InteractiveInput asks for every template value in a form, starting from the values already set.
 func (ts *TemplateService) InteractiveInput() error {
-	form, _ := ui.RenderForm(ts.TemplateValuesMap)
+	form, formMap := ui.RenderForm(ts.TemplateValuesMap)
 
 	err := form.Run()
 	if err != nil {
 		return err
 	}
+
+	ui.Rebuild(formMap, ts.TemplateValuesMap)
+	return nil
+}
+
+/*
+ApplyValueDefaults sets each of defaults, keyed by a dotted path such as terraform.version, in the
+TemplateValuesMap where CreateTemplateValuesMap created the key and it holds no value yet. Defaults for
+keys no template uses are dropped. Values from a values file or the interactive form are set over them.
+It returns the keys it set, sorted.
+*/
+func (ts *TemplateService) ApplyValueDefaults(defaults map[string]string) []string {
+	if ts.TemplateValuesMap == nil {
+		ts.TemplateValuesMap = make(types.TemplateValuesMap)
+	}
+
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	applied := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if setValueDefault(ts.TemplateValuesMap, strings.Split(key, "."), defaults[key]) {
+			applied = append(applied, key)
+		}
+	}
+
+	return applied
+}
+
+// setValueDefault sets value at the path keys in values when the path exists and holds no value.
+func setValueDefault(values map[string]any, keys []string, value string) bool {
+	v, ok := values[keys[0]]
+	if !ok {
+		return false
+	}
+
+	if len(keys) == 1 {
+		if v != "" {
+			return false
+		}
+		values[keys[0]] = value
+		return true
+	}
+
+	nested, ok := v.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	return setValueDefault(nested, keys[1:], value)
+}
+
+// DropUnsetValues removes the keys of the TemplateValuesMap that hold no value yet, so templates using them fail.
+func (ts *TemplateService) DropUnsetValues() {
+	dropUnsetValues(ts.TemplateValuesMap)
+}
+
+func dropUnsetValues(values map[string]any) {
+	for key, value := range values {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				delete(values, key)
+			}
+		case map[string]any:
+			dropUnsetValues(v)
+			if len(v) == 0 {
+				delete(values, key)
+			}
+		}
+	}
+}
+
+// MergeValues sets values over the TemplateValuesMap, merging nested maps key by key.
+func (ts *TemplateService) MergeValues(values map[string]any) {
+	if ts.TemplateValuesMap == nil {
+		ts.TemplateValuesMap = make(types.TemplateValuesMap)
+	}
+	mergeValues(ts.TemplateValuesMap, values)
+}
+
+func mergeValues(dst, src map[string]any) {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]any); ok {
+			if dstMap, isMap := dst[key].(map[string]any); isMap {
+				mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// MarshalValues marshals the TemplateValuesMap to YAML, with comment on the values of marked, keyed by dotted path.
+func (ts *TemplateService) MarshalValues(marked []string, comment string) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(map[string]any(ts.TemplateValuesMap)); err != nil {
+		return nil, err
+	}
+
+	for _, key := range marked {
+		if value := yamlValueNode(&node, strings.Split(key, ".")); value != nil {
+			value.LineComment = comment
+		}
+	}
+
+	return yaml.Marshal(&node)
+}
+
+// yamlValueNode returns the node of the value at the path keys in mapping, or nil when it holds none.
+func yamlValueNode(mapping *yaml.Node, keys []string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			return mapping.Content[i+1]
+		}
+		return yamlValueNode(mapping.Content[i+1], keys[1:])
+	}
 	return nil
 }
 
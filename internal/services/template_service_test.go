@@ -565,3 +565,133 @@ func TestRenameTargetTemplateFiles(t *testing.T) { //nolint:gocognit
 		})
 	}
 }
+
+func TestApplyValueDefaults(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name            string
+		values          types.TemplateValuesMap
+		defaults        map[string]string
+		dropUnset       bool
+		fileValues      map[string]any
+		expectedValues  types.TemplateValuesMap
+		expectedApplied []string
+	}{
+		{
+			name:   "Defaults fill empty flat and dotted keys",
+			values: types.TemplateValuesMap{"Name": "", "terraform": map[string]any{"version": ""}},
+			defaults: map[string]string{
+				"Name":              "api",
+				"terraform.version": "1.9.0",
+			},
+			expectedValues: types.TemplateValuesMap{
+				"Name":      "api",
+				"terraform": map[string]any{"version": "1.9.0"},
+			},
+			expectedApplied: []string{"Name", "terraform.version"},
+		},
+		{
+			name:   "Defaults for keys the templates don't use are dropped",
+			values: types.TemplateValuesMap{"Name": "", "terraform": map[string]any{"version": ""}},
+			defaults: map[string]string{
+				"cloud.region":      "eu-west-1",
+				"Owner":             "platform",
+				"terraform.backend": "s3",
+			},
+			expectedValues:  types.TemplateValuesMap{"Name": "", "terraform": map[string]any{"version": ""}},
+			expectedApplied: []string{},
+		},
+		{
+			name:   "Set values are kept",
+			values: types.TemplateValuesMap{"Name": "web", "cloud": "aws"},
+			defaults: map[string]string{
+				"Name":         "api",
+				"cloud.region": "eu-west-1",
+			},
+			expectedValues:  types.TemplateValuesMap{"Name": "web", "cloud": "aws"},
+			expectedApplied: []string{},
+		},
+		{
+			name: "Values file is merged over defaults",
+			values: types.TemplateValuesMap{
+				"Name":      "",
+				"terraform": map[string]any{"version": "", "backend": ""},
+			},
+			defaults: map[string]string{
+				"Name":              "api",
+				"terraform.version": "1.9.0",
+				"terraform.backend": "s3",
+			},
+			fileValues: map[string]any{
+				"terraform": map[string]any{"version": "1.10.0"},
+			},
+			expectedValues: types.TemplateValuesMap{
+				"Name":      "api",
+				"terraform": map[string]any{"version": "1.10.0", "backend": "s3"},
+			},
+			expectedApplied: []string{"Name", "terraform.backend", "terraform.version"},
+		},
+		{
+			name: "Unset values are dropped before the values file is merged",
+			values: types.TemplateValuesMap{
+				"Name":      "",
+				"Owner":     "",
+				"terraform": map[string]any{"version": "", "backend": ""},
+				"cloud":     map[string]any{"region": ""},
+			},
+			defaults: map[string]string{
+				"terraform.backend": "s3",
+			},
+			dropUnset:  true,
+			fileValues: map[string]any{"Name": "api"},
+			expectedValues: types.TemplateValuesMap{
+				"Name":      "api",
+				"terraform": map[string]any{"backend": "s3"},
+			},
+			expectedApplied: []string{"terraform.backend"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := services.NewTemplateService(&storage.SafeFs{Fs: afero.NewMemMapFs()})
+			service.TemplateValuesMap = tt.values
+
+			// Act
+			applied := service.ApplyValueDefaults(tt.defaults)
+			if tt.dropUnset {
+				service.DropUnsetValues()
+			}
+			service.MergeValues(tt.fileValues)
+
+			// Assert
+			if !reflect.DeepEqual(applied, tt.expectedApplied) {
+				t.Errorf("expected applied keys %v, got %v", tt.expectedApplied, applied)
+			}
+			if !reflect.DeepEqual(service.TemplateValuesMap, tt.expectedValues) {
+				t.Errorf("expected values map %v, got %v", tt.expectedValues, service.TemplateValuesMap)
+			}
+		})
+	}
+}
+
+func TestMarshalValues(t *testing.T) {
+	// Arrange
+	service := services.NewTemplateService(&storage.SafeFs{Fs: afero.NewMemMapFs()})
+	service.TemplateValuesMap = types.TemplateValuesMap{
+		"Name":      "",
+		"terraform": map[string]any{"version": "1.9.0"},
+	}
+	expected := "Name: \"\"\nterraform:\n    version: 1.9.0 # default from source set goWebSet\n"
+
+	// Act
+	p, err := service.MarshalValues([]string{"terraform.version"}, "default from source set goWebSet")
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(p) != expected {
+		t.Errorf("expected %q, got %q", expected, string(p))
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// archiveContentTypes maps the content types archives are commonly served with to a file extension.
+var archiveContentTypes = map[string]string{ //nolint:gochecknoglobals // read only lookup
+	"application/gzip":             archiveExtTarGz,
+	"application/x-gzip":           archiveExtTarGz,
+	"application/x-gtar":           archiveExtTarGz,
+	"application/x-compressed-tar": archiveExtTarGz,
+	"application/zip":              archiveExtZip,
+	"application/x-zip-compressed": archiveExtZip,
+}
+
+type ArchiveClient struct {
+	CurrentSource *types.ArchiveSource
+	HTTPClient    *http.Client
+}
+
+// NewArchiveClient creates a new ArchiveClient.
+func NewArchiveClient() *ArchiveClient {
+	return &ArchiveClient{}
+}
+
+/*
+Clone downloads the .tar.gz, .tgz or .zip archive at the source URL and unpacks it into an in-memory
+filesystem, verifying it against Source.SHA256 when one is set. A Token on the source's auth is sent
+as a bearer token, otherwise UserName and Pat are sent as basic auth.
+*/
+func (ac *ArchiveClient) Clone(ctx context.Context) (billy.Filesystem, error) {
+	resp, err := ac.download(ctx)
+	if err != nil {
+		return nil, &ArchiveError{Name: ac.CurrentSource.URL, OpErr: err}
+	}
+	defer resp.Body.Close()
+
+	name := archiveName(resp)
+
+	hash := sha256.New()
+	body := io.TeeReader(resp.Body, hash)
+
+	mfs := memfs.New()
+
+	if err = UnpackArchive(name, body, mfs); err != nil {
+		return nil, err
+	}
+
+	if ac.CurrentSource.SHA256 != "" {
+		// Trailing bytes after the end of archive marker still count towards the checksum.
+		if _, err = io.Copy(io.Discard, body); err != nil {
+			return nil, &ArchiveError{Name: ac.CurrentSource.URL, OpErr: err}
+		}
+
+		actual := hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(actual, ac.CurrentSource.SHA256) {
+			return nil, &ChecksumMismatchError{
+				URL:      ac.CurrentSource.URL,
+				Expected: ac.CurrentSource.SHA256,
+				Actual:   actual,
+			}
+		}
+	}
+
+	return ChrootSourcePath(mfs, ac.CurrentSource.Path)
+}
+
+// Pinned returns the source's SHA256 as its revision, when one is set, as the archive can't change under it.
+func (ac *ArchiveClient) Pinned() (string, bool) {
+	if ac.CurrentSource.SHA256 == "" {
+		return "", false
+	}
+	return "sha256:" + strings.ToLower(ac.CurrentSource.SHA256), true
+}
+
+// SetSource sets the current source.
+func (ac *ArchiveClient) SetSource(s *types.Source) {
+	ac.CurrentSource = (*types.ArchiveSource)(s)
+}
+
+func (ac *ArchiveClient) httpClient() *http.Client {
+	if ac.HTTPClient != nil {
+		return ac.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (ac *ArchiveClient) download(ctx context.Context) (*http.Response, error) {
+	u, err := url.Parse(ac.CurrentSource.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != blobURLSchemeHTTP && u.Scheme != blobURLSchemeHTTPS {
+		return nil, errUnsupportedArchiveURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth := ac.CurrentSource.SourceAuth; auth != nil {
+		switch {
+		case auth.Token != "":
+			req.Header.Set("Authorization", "Bearer "+auth.Token)
+		case auth.Pat != "":
+			req.SetBasicAuth(auth.UserName, auth.Pat)
+		}
+	}
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &BlobResponseError{StatusCode: resp.StatusCode, Key: u.Path}
+	}
+
+	return resp, nil
+}
+
+/*
+archiveName returns a file name that identifies the archive format of resp. The URL path is preferred,
+then the Content-Disposition filename, then the Content-Type, which covers release endpoints that
+serve archives from extension-less URLs.
+*/
+func archiveName(resp *http.Response) string {
+	name := path.Base(resp.Request.URL.Path)
+	if IsArchive(name) {
+		return name
+	}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if filename := params["filename"]; IsArchive(filename) {
+			return filename
+		}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if ext, ok := archiveContentTypes[mediaType]; ok {
+			return name + ext
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,263 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveClient_Clone(t *testing.T) {
+	// Arrange
+	tarball := buildTarGz(t, map[string]string{
+		"tmpltr.terraform.child-1.4.0/versions.tf.template": `required_version = "{{.terraformVersion}}"`,
+		"tmpltr.terraform.child-1.4.0/main.tf":              `module "child" {}`,
+	})
+	zipball := buildZip(t, map[string]string{
+		"docs/README.md": "# docs",
+	})
+	tarballSum := sha256.Sum256(tarball)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases/child-1.4.0.tar.gz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(tarball)
+	})
+	mux.HandleFunc("/releases/docs.zip", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(zipball)
+	})
+	mux.HandleFunc("/api/releases/latest/asset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ghp_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="child-1.4.0.tgz"`)
+		_, _ = w.Write(tarball)
+	})
+	mux.HandleFunc("/api/releases/latest/zipball", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		_, _ = w.Write(zipball)
+	})
+	mux.HandleFunc("/redirect/docs.zip", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/api/releases/latest/zipball", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name          string
+		source        types.Source
+		expectedErr   error
+		expectedFiles map[string]string
+	}{
+		{
+			name: "unpacks tarball and applies path with matching checksum",
+			source: types.Source{
+				URL:    server.URL + "/releases/child-1.4.0.tar.gz",
+				Path:   "/tmpltr.terraform.child-1.4.0",
+				SHA256: hex.EncodeToString(tarballSum[:]),
+			},
+			expectedFiles: map[string]string{
+				"versions.tf.template": `required_version = "{{.terraformVersion}}"`,
+				"main.tf":              `module "child" {}`,
+			},
+		},
+		{
+			name: "sends basic auth from username and pat",
+			source: types.Source{
+				URL:        server.URL + "/releases/docs.zip",
+				SourceAuth: &types.SourceAuth{AuthAlias: "artifacts", UserName: "ci", Pat: "s3cret"},
+			},
+			expectedFiles: map[string]string{
+				"docs/README.md": "# docs",
+			},
+		},
+		{
+			name: "sends bearer token and detects format from content disposition",
+			source: types.Source{
+				URL:        server.URL + "/api/releases/latest/asset",
+				Path:       "/tmpltr.terraform.child-1.4.0",
+				SourceAuth: &types.SourceAuth{AuthAlias: "github", Token: "ghp_token"},
+			},
+			expectedFiles: map[string]string{
+				"main.tf": `module "child" {}`,
+			},
+		},
+		{
+			name:   "follows redirects and detects format from content type",
+			source: types.Source{URL: server.URL + "/redirect/docs.zip"},
+			expectedFiles: map[string]string{
+				"docs/README.md": "# docs",
+			},
+		},
+		{
+			name: "returns error on checksum mismatch",
+			source: types.Source{
+				URL:    server.URL + "/releases/child-1.4.0.tar.gz",
+				SHA256: "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			expectedErr: &storage.ChecksumMismatchError{},
+		},
+		{
+			name:        "returns error when auth is missing",
+			source:      types.Source{URL: server.URL + "/releases/docs.zip"},
+			expectedErr: &storage.ArchiveError{},
+		},
+		{
+			name:        "returns error for non http url",
+			source:      types.Source{URL: "ftp://example.com/child.tar.gz"},
+			expectedErr: &storage.ArchiveError{},
+		},
+		{
+			name: "returns error when path does not exist in archive",
+			source: types.Source{
+				URL:        server.URL + "/releases/docs.zip",
+				Path:       "/missing",
+				SourceAuth: &types.SourceAuth{AuthAlias: "artifacts", UserName: "ci", Pat: "s3cret"},
+			},
+			expectedErr: &storage.SourcePathError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			ac := storage.NewArchiveClient()
+			ac.HTTPClient = server.Client()
+			tt.source.SourceType = types.ArchiveSourceType
+			tt.source.Alias = "release"
+			ac.SetSource(&tt.source)
+
+			// Act
+			bfs, err := ac.Clone(t.Context())
+
+			// Assert
+			switch e := tt.expectedErr.(type) {
+			case *storage.ChecksumMismatchError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.ArchiveError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.SourcePathError:
+				require.ErrorAs(t, err, &e)
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+		})
+	}
+}
+
+func TestUnpackArchive_Symlinks(t *testing.T) {
+	// Arrange
+	type entry struct{ name, link string }
+	tests := []struct {
+		name      string
+		entries   []entry
+		expectErr bool
+	}{
+		{
+			name:    "links within the archive",
+			entries: []entry{{"dir/file.txt", ""}, {"dir/link.txt", "file.txt"}, {"up", "dir/.."}},
+		},
+		{
+			name:      "link out of the archive",
+			entries:   []entry{{"dir/link", "../../outside"}},
+			expectErr: true,
+		},
+		{
+			name:      "absolute link",
+			entries:   []entry{{"link", "/etc/passwd"}},
+			expectErr: true,
+		},
+		{
+			name:      "link out of the archive through an earlier link",
+			entries:   []entry{{"here", "."}, {"up", "here/.."}},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gz)
+			for _, e := range tt.entries {
+				hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg}
+				if e.link != "" {
+					hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, e.link
+				}
+				require.NoError(t, tw.WriteHeader(hdr))
+			}
+			require.NoError(t, tw.Close())
+			require.NoError(t, gz.Close())
+
+			// Act
+			err := storage.UnpackArchive("links.tar.gz", &buf, memfs.New())
+
+			// Assert
+			if !tt.expectErr {
+				require.NoError(t, err)
+				return
+			}
+			var linkErr *storage.UnsafeSymlinkError
+			require.ErrorAs(t, err, &linkErr)
+		})
+	}
+}
+
+func TestArchiveClient_Pinned(t *testing.T) {
+	tests := []struct {
+		name             string
+		sha256           string
+		expectedRevision string
+		expectedPinned   bool
+	}{
+		{
+			name:             "pins to the lowercased checksum",
+			sha256:           "ABC123",
+			expectedRevision: "sha256:abc123",
+			expectedPinned:   true,
+		},
+		{
+			name: "is not pinned without a checksum",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			ac := storage.NewArchiveClient()
+			ac.SetSource(&types.Source{URL: "https://example.com/templates.tar.gz", SHA256: tt.sha256})
+
+			// Act
+			revision, pinned := ac.Pinned()
+
+			// Assert
+			assert.Equal(t, tt.expectedPinned, pinned)
+			assert.Equal(t, tt.expectedRevision, revision)
+		})
+	}
+}
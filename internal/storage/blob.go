@@ -2,13 +2,64 @@ package storage
 
 import (
 	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 )
 
+const (
+	blobURLSchemeS3    = "s3"
+	blobURLSchemeAzure = "azblob"
+	blobURLSchemeHTTP  = "http"
+	blobURLSchemeHTTPS = "https"
+)
+
+/*
+blobStore is implemented by each object storage backend a BlobClient can fetch from.
+Keys are always the full object key within the bucket or container.
+*/
+type blobStore interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// errorBodyMaxLen bounds how much of an error response's body responseError reads.
+const errorBodyMaxLen = 1 << 16
+
+// xmlErrorBody is the error body of S3 and Azure Blob Storage.
+type xmlErrorBody struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+/*
+responseError builds the BlobResponseError for key from an unsuccessful response of an object store or
+registry, with the code and message decode reads from its body. Error bodies are small documents, and
+one that can't be decoded still leaves the status code.
+*/
+func responseError(resp *http.Response, key string, decode func(io.Reader) (string, string)) error {
+	code, message := decode(io.LimitReader(resp.Body, errorBodyMaxLen))
+	return &BlobResponseError{StatusCode: resp.StatusCode, Key: key, Code: code, Message: message}
+}
+
+// decodeXMLErrorBody decodes the code and message of an S3 or Azure Blob Storage error body.
+func decodeXMLErrorBody(r io.Reader) (string, string) {
+	var body xmlErrorBody
+	_ = xml.NewDecoder(r).Decode(&body)
+	return body.Code, body.Message
+}
+
 type BlobClient struct {
 	CurrentSource *types.BlobSource
+	HTTPClient    *http.Client
 }
 
 // NewBlobClient creates a new BlobClient.
@@ -16,11 +67,177 @@ func NewBlobClient() *BlobClient {
 	return &BlobClient{}
 }
 
-func (bc *BlobClient) Clone(_ context.Context) (billy.Filesystem, error) {
-	// TODO: Implement git storage source cloning/downloading
-	return nil, ErrNotImplemented
+/*
+Clone fetches every object under the bucket/prefix referenced by the source URL into an in-memory
+filesystem. When the URL references a single .tar.gz, .tgz or .zip object it is unpacked instead.
+
+Supported URLs:
+  - s3://bucket/prefix, resolved against AWS using Source.Region.
+  - azblob://account/container/prefix or https://account.blob.core.windows.net/container/prefix.
+  - http(s)://endpoint/bucket/prefix, a path-style S3-compatible endpoint such as MinIO, or with
+    Source.Provider set to azure, a path-style Azure endpoint such as Azurite.
+*/
+func (bc *BlobClient) Clone(ctx context.Context) (billy.Filesystem, error) {
+	store, prefix, err := bc.newBlobStore()
+	if err != nil {
+		return nil, &BlobError{URL: bc.CurrentSource.URL, OpErr: err}
+	}
+
+	mfs := memfs.New()
+
+	if IsArchive(prefix) {
+		err = fetchBlobArchive(ctx, store, prefix, mfs)
+	} else {
+		err = fetchBlobPrefix(ctx, store, prefix, mfs)
+	}
+	if err != nil {
+		return nil, &BlobError{URL: bc.CurrentSource.URL, OpErr: err}
+	}
+
+	return ChrootSourcePath(mfs, bc.CurrentSource.Path)
 }
 
 func (bc *BlobClient) SetSource(s *types.Source) {
 	bc.CurrentSource = (*types.BlobSource)(s)
 }
+
+func (bc *BlobClient) httpClient() *http.Client {
+	if bc.HTTPClient != nil {
+		return bc.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// newBlobStore selects a backend for the source URL and returns it with the object prefix to fetch.
+func (bc *BlobClient) newBlobStore() (blobStore, string, error) {
+	u, err := url.Parse(bc.CurrentSource.URL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch bc.provider(u) {
+	case types.S3BlobProvider:
+		return newS3Store(bc.httpClient(), u, bc.region(), bc.s3Credentials())
+	case types.AzureBlobProvider:
+		return newAzureStore(bc.httpClient(), u, bc.azureCredentials())
+	default:
+		return nil, "", errUnsupportedBlobURL
+	}
+}
+
+// provider returns the configured provider, or infers one from the URL when none is set.
+func (bc *BlobClient) provider(u *url.URL) types.BlobProvider {
+	if bc.CurrentSource.Provider != "" {
+		return bc.CurrentSource.Provider
+	}
+
+	switch u.Scheme {
+	case blobURLSchemeAzure:
+		return types.AzureBlobProvider
+	case blobURLSchemeS3:
+		return types.S3BlobProvider
+	case blobURLSchemeHTTP, blobURLSchemeHTTPS:
+		if strings.HasSuffix(u.Hostname(), azureBlobHostSuffix) {
+			return types.AzureBlobProvider
+		}
+		return types.S3BlobProvider
+	default:
+		return ""
+	}
+}
+
+// region returns the configured region, falling back to the standard AWS environment variables.
+func (bc *BlobClient) region() string {
+	for _, r := range []string{bc.CurrentSource.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if r != "" {
+			return r
+		}
+	}
+	return s3DefaultRegion
+}
+
+// s3Credentials maps SourceAuth onto S3 credentials: UserName is the access key ID, Key the secret
+// access key and Token an optional session token. Without a SourceAuth the standard AWS environment
+// variables are used, and without those requests are sent unsigned.
+func (bc *BlobClient) s3Credentials() s3Credentials {
+	if auth := bc.CurrentSource.SourceAuth; auth != nil && auth.Key != "" {
+		return s3Credentials{
+			AccessKeyID:     auth.UserName,
+			SecretAccessKey: auth.Key,
+			SessionToken:    auth.Token,
+		}
+	}
+	return s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// azureCredentials maps SourceAuth onto Azure credentials: Token is a SAS token and Key the account's
+// shared key. Without either, AZURE_STORAGE_SAS_TOKEN and AZURE_STORAGE_KEY are used, and without those
+// requests are sent anonymously, as for a public container.
+func (bc *BlobClient) azureCredentials() azureCredentials {
+	if auth := bc.CurrentSource.SourceAuth; auth != nil && (auth.Token != "" || auth.Key != "") {
+		return azureCredentials{
+			SASToken:  auth.Token,
+			SharedKey: auth.Key,
+		}
+	}
+	return azureCredentials{
+		SASToken:  os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		SharedKey: os.Getenv("AZURE_STORAGE_KEY"),
+	}
+}
+
+// fetchBlobPrefix writes every object under prefix into fs, relative to prefix.
+func fetchBlobPrefix(ctx context.Context, store blobStore, prefix string, fs billy.Filesystem) error {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	fetched := 0
+	for _, key := range keys {
+		// Zero byte "folder" markers created by some consoles.
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		if err = fetchBlobObject(ctx, store, key, path.Join("/", strings.TrimPrefix(key, prefix)), fs); err != nil {
+			return err
+		}
+		fetched++
+	}
+
+	if fetched == 0 {
+		return errNoBlobObjects
+	}
+
+	return nil
+}
+
+func fetchBlobObject(ctx context.Context, store blobStore, key string, dest string, fs billy.Filesystem) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return writeFile(fs, dest, 0644, body) //nolint:mnd
+}
+
+// fetchBlobArchive downloads a single archive object and unpacks it into fs.
+func fetchBlobArchive(ctx context.Context, store blobStore, key string, fs billy.Filesystem) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return UnpackArchive(key, body, fs)
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	azureBlobHostSuffix = ".blob.core.windows.net"
+	azureAPIVersion     = "2021-08-06"
+	azureListPageMaxLen = 5000
+)
+
+type azureCredentials struct {
+	// SharedKey is the base64 encoded storage account key.
+	SharedKey string
+	// SASToken is a service or account shared access signature, with or without a leading "?".
+	SASToken string
+}
+
+/*
+azureStore reads blobs from an Azure Storage container, authorising requests with either a SAS
+token or the account's shared key.
+*/
+type azureStore struct {
+	client *http.Client
+	// base is the container URL, e.g. https://account.blob.core.windows.net/container.
+	base      *url.URL
+	account   string
+	container string
+	creds     azureCredentials
+	now       func() time.Time
+}
+
+type azureEnumerationResults struct {
+	Blobs struct {
+		Blob []struct {
+			Name string `xml:"Name"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+/*
+newAzureStore builds an azureStore from a source URL, returning it with the blob prefix within the container.
+azblob://account/container/prefix and https://account.blob.core.windows.net/container/prefix URLs are
+addressed against the account's subdomain. Any other http(s) URL is treated as path-style, with the account
+as the first path segment, as used by the Azurite emulator: http://127.0.0.1:10000/account/container/prefix.
+*/
+func newAzureStore(client *http.Client, u *url.URL, creds azureCredentials) (*azureStore, string, error) {
+	store := &azureStore{
+		client: client,
+		creds:  creds,
+		now:    time.Now,
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 3) //nolint:mnd
+
+	var prefix string
+
+	switch {
+	case u.Scheme == blobURLSchemeAzure:
+		store.account = u.Host
+		store.container = segments[0]
+		store.base = &url.URL{Scheme: blobURLSchemeHTTPS, Host: u.Host + azureBlobHostSuffix, Path: "/" + segments[0]}
+		prefix = strings.Join(segments[1:], "/")
+	case strings.HasSuffix(u.Hostname(), azureBlobHostSuffix):
+		store.account = strings.TrimSuffix(u.Hostname(), azureBlobHostSuffix)
+		store.container = segments[0]
+		store.base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + segments[0]}
+		prefix = strings.Join(segments[1:], "/")
+	default:
+		if len(segments) < 2 { //nolint:mnd
+			return nil, "", errNoBlobContainer
+		}
+		store.account = segments[0]
+		store.container = segments[1]
+		store.base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + segments[0] + "/" + segments[1]}
+		if len(segments) == 3 { //nolint:mnd
+			prefix = segments[2]
+		}
+	}
+
+	if store.account == "" || store.container == "" {
+		return nil, "", errNoBlobContainer
+	}
+
+	return store, prefix, nil
+}
+
+// List returns the names of every blob under prefix, following continuation markers.
+func (a *azureStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	marker := ""
+
+	for {
+		query := url.Values{
+			"restype":    {"container"},
+			"comp":       {"list"},
+			"maxresults": {fmt.Sprint(azureListPageMaxLen)},
+		}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		body, err := a.do(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result azureEnumerationResults
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode azure blob listing: %w", err)
+		}
+
+		for _, b := range result.Blobs.Blob {
+			names = append(names, b.Name)
+		}
+
+		if result.NextMarker == "" {
+			return names, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// Get returns the content of the blob called name. The caller must close it.
+func (a *azureStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return a.do(ctx, name, nil)
+}
+
+func (a *azureStore) do(ctx context.Context, name string, query url.Values) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.blobURL(name, query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	req.Header.Set("X-Ms-Date", a.now().UTC().Format(http.TimeFormat))
+
+	if a.creds.SASToken == "" && a.creds.SharedKey != "" {
+		if err = a.signSharedKey(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp, name, decodeXMLErrorBody)
+	}
+
+	return resp.Body, nil
+}
+
+// blobURL builds the request URL for the blob called name, appending the SAS token when one is set.
+func (a *azureStore) blobURL(name string, query url.Values) string {
+	u := *a.base
+	if name != "" {
+		u.Path += "/" + name
+	}
+
+	rawQuery := query.Encode()
+	if sas := strings.TrimPrefix(a.creds.SASToken, "?"); sas != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += sas
+	}
+	u.RawQuery = rawQuery
+
+	return u.String()
+}
+
+// signSharedKey adds a Shared Key Authorization header to req.
+func (a *azureStore) signSharedKey(req *http.Request) error {
+	key, err := base64.StdEncoding.DecodeString(a.creds.SharedKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode azure shared key: %w", err)
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(a.stringToSign(req)))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, signature))
+	return nil
+}
+
+// stringToSign builds the Shared Key string-to-sign for the Blob service.
+func (a *azureStore) stringToSign(req *http.Request) string {
+	standardHeaders := []string{
+		"Content-Encoding", "Content-Language", "Content-Length", "Content-Md5", "Content-Type", "Date",
+		"If-Modified-Since", "If-Match", "If-None-Match", "If-Unmodified-Since", "Range",
+	}
+
+	lines := []string{req.Method}
+	for _, name := range standardHeaders {
+		v := req.Header.Get(name)
+		if name == "Content-Length" && v == "0" {
+			v = ""
+		}
+		lines = append(lines, v)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + a.account + req.URL.EscapedPath()
+
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	return strings.Join(lines, "\n") + "\n" + strings.Join(msHeaders, "\n") + "\n" + resource
+}
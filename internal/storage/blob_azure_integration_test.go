@@ -0,0 +1,83 @@
+//go:build integration
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// Azurite's well known development account.
+	azuriteAccount    = "devstoreaccount1"
+	azuriteAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// azuritePut issues a shared key signed PUT against the emulator.
+func azuritePut(t *testing.T, store *azureStore, name string, query url.Values, body []byte, headers map[string]string) {
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, store.blobURL(name, query), bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	req.Header.Set("X-Ms-Date", store.now().UTC().Format(http.TimeFormat))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	require.NoError(t, store.signSharedKey(req))
+
+	resp, err := store.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// 409 means the container already exists from a previous run.
+	require.Contains(t, []int{http.StatusCreated, http.StatusConflict}, resp.StatusCode)
+}
+
+func TestBlobClient_CloneAzurite(t *testing.T) {
+	// Arrange
+	endpoint := os.Getenv("TMPLTR_AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:10000"
+	}
+	if _, err := http.Get(endpoint); err != nil { //nolint:noctx // reachability probe only
+		t.Skipf("azurite not reachable at %s: %s", endpoint, err)
+	}
+
+	containerURL := fmt.Sprintf("%s/%s/tmpltr-integration", endpoint, azuriteAccount)
+	u, err := url.Parse(containerURL)
+	require.NoError(t, err)
+	store, _, err := newAzureStore(http.DefaultClient, u, azureCredentials{SharedKey: azuriteAccountKey})
+	require.NoError(t, err)
+
+	azuritePut(t, store, "", url.Values{"restype": {"container"}}, nil, nil)
+	azuritePut(t, store, "vscode/terraform/settings.json", nil, []byte(`{"editor.formatOnSave": true}`),
+		map[string]string{"X-Ms-Blob-Type": "BlockBlob"})
+
+	bc := NewBlobClient()
+	bc.SetSource(&types.Source{
+		Alias:      "vscode",
+		SourceType: types.BlobSourceType,
+		Provider:   types.AzureBlobProvider,
+		URL:        containerURL + "/vscode",
+		Path:       "/terraform",
+		SourceAuth: &types.SourceAuth{AuthAlias: "azurite", Key: azuriteAccountKey},
+	})
+
+	// Act
+	bfs, err := bc.Clone(t.Context())
+
+	// Assert
+	require.NoError(t, err)
+	content, err := util.ReadFile(bfs, "settings.json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"editor.formatOnSave": true}`, string(content))
+}
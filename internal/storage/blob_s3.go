@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultRegion  = "us-east-1"
+	s3SigningAlg     = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat  = "20060102T150405Z"
+	s3ScopeDateFmt   = "20060102"
+	s3EmptyBodySHA   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3ListPageMaxLen = 1000
+)
+
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+/*
+s3Store reads objects from AWS S3 or any S3-compatible endpoint, signing requests with AWS
+Signature Version 4 when credentials are available.
+*/
+type s3Store struct {
+	client *http.Client
+	// endpoint is the scheme and host requests are sent to, e.g. https://s3.eu-west-1.amazonaws.com.
+	endpoint *url.URL
+	bucket   string
+	// virtualHost addresses the bucket as a subdomain of endpoint rather than as the first path segment.
+	virtualHost bool
+	region      string
+	creds       s3Credentials
+	now         func() time.Time
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+/*
+newS3Store builds an s3Store from a source URL, returning it with the object prefix within the bucket.
+s3://bucket/prefix URLs are addressed virtual-host style against AWS, while http(s)://host/bucket/prefix
+URLs are addressed path-style against the given host.
+*/
+func newS3Store(client *http.Client, u *url.URL, region string, creds s3Credentials) (*s3Store, string, error) {
+	store := &s3Store{
+		client: client,
+		region: region,
+		creds:  creds,
+		now:    time.Now,
+	}
+
+	var prefix string
+
+	if u.Scheme == blobURLSchemeS3 {
+		store.bucket = u.Host
+		store.virtualHost = true
+		store.endpoint = &url.URL{Scheme: blobURLSchemeHTTPS, Host: fmt.Sprintf("s3.%s.amazonaws.com", region)}
+		prefix = strings.TrimPrefix(u.Path, "/")
+	} else {
+		bucket, rest, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		store.bucket = bucket
+		store.endpoint = &url.URL{Scheme: u.Scheme, Host: u.Host}
+		prefix = rest
+	}
+
+	if store.bucket == "" {
+		return nil, "", errNoBlobContainer
+	}
+
+	return store, prefix, nil
+}
+
+// List returns the keys of every object under prefix, following continuation tokens.
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"max-keys":  {fmt.Sprint(s3ListPageMaxLen)},
+		}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := s.do(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 object listing: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get returns the content of the object at key. The caller must close it.
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.do(ctx, key, nil)
+}
+
+func (s *s3Store) do(ctx context.Context, key string, query url.Values) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key, query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp, key, decodeXMLErrorBody)
+	}
+
+	return resp.Body, nil
+}
+
+// objectURL builds the request URL for key, encoding it exactly as it will be signed.
+func (s *s3Store) objectURL(key string, query url.Values) string {
+	u := *s.endpoint
+
+	p := "/" + key
+	if s.virtualHost {
+		u.Host = s.bucket + "." + u.Host
+	} else {
+		p = "/" + s.bucket + p
+	}
+
+	u.Path = p
+	u.RawPath = s3EscapePath(p)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	return u.String()
+}
+
+// sign adds AWS Signature Version 4 headers to req. Requests are left unsigned without credentials.
+func (s *s3Store) sign(req *http.Request) {
+	if s.creds.AccessKeyID == "" || s.creds.SecretAccessKey == "" {
+		return
+	}
+
+	t := s.now().UTC()
+	amzDate := t.Format(s3AmzDateFormat)
+	scopeDate := t.Format(s3ScopeDateFmt)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyBodySHA)
+	if s.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": s3EmptyBodySHA,
+		"x-amz-date":           amzDate,
+	}
+	if s.creds.SessionToken != "" {
+		headers["x-amz-security-token"] = s.creds.SessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyBodySHA,
+	}, "\n")
+
+	scope := strings.Join([]string{scopeDate, s.region, "s3", "aws4_request"}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3SigningAlg, amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), scopeDate)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlg, s.creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath URI encodes each segment of p as required by the SigV4 canonical request.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = s3Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes query with sorted keys, as required by the SigV4 canonical request.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes every byte other than the RFC 3986 unreserved characters.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,426 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTarGz creates a gzipped tarball containing files.
+func buildTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// buildZip creates a zip archive containing files.
+func buildZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// fakeS3 is a minimal path-style S3 endpoint serving objects from a single bucket.
+type fakeS3 struct {
+	bucket  string
+	objects map[string][]byte
+	// pageSize forces pagination of listings so continuation tokens are exercised.
+	pageSize int
+	// requireAuth rejects requests that are not SigV4 signed with this access key ID.
+	requireAuth string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.requireAuth != "" &&
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+f.requireAuth+"/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>NoSuchBucket</Code><Message>no bucket</Message></Error>`))
+		return
+	}
+
+	if r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r)
+		return
+	}
+
+	content, ok := f.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>no key</Message></Error>`))
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	type content struct {
+		Key string `xml:"Key"`
+	}
+	type result struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	res := result{}
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		res.Contents = append(res.Contents, content{Key: k})
+	}
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func TestBlobClient_CloneS3(t *testing.T) {
+	// Arrange
+	fake := &fakeS3{
+		bucket: "templates",
+		objects: map[string][]byte{
+			"go/web/main.go.template":       []byte("package {{.packageName}}"),
+			"go/web/cmd/root.go":            []byte("package cmd"),
+			"go/web/":                       {},
+			"go/service/main.go":            []byte("package main"),
+			"releases/terraform-1.2.tar.gz": buildTarGz(t, map[string]string{"child/versions.tf": "terraform {}"}),
+			"releases/vscode-1.0.zip":       buildZip(t, map[string]string{".vscode/settings.json": "{}"}),
+			"releases/evil.tar.gz":          buildTarGz(t, map[string]string{"../../escape.txt": "nope"}),
+		},
+		pageSize:    1,
+		requireAuth: "minioadmin",
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	auth := &types.SourceAuth{AuthAlias: "minio", UserName: "minioadmin", Key: "minioadmin"}
+
+	tests := []struct {
+		name          string
+		source        types.Source
+		expectedErr   error
+		expectedFiles map[string]string
+		absentFiles   []string
+	}{
+		{
+			name:   "fetches every object under prefix",
+			source: types.Source{URL: server.URL + "/templates/go/web", Path: "/", SourceAuth: auth},
+			expectedFiles: map[string]string{
+				"main.go.template": "package {{.packageName}}",
+				"cmd/root.go":      "package cmd",
+			},
+			absentFiles: []string{"main.go", "go/service/main.go"},
+		},
+		{
+			name:   "applies path as sub-root",
+			source: types.Source{URL: server.URL + "/templates/go", Path: "/web/cmd", SourceAuth: auth},
+			expectedFiles: map[string]string{
+				"root.go": "package cmd",
+			},
+		},
+		{
+			name:   "unpacks tar.gz object",
+			source: types.Source{URL: server.URL + "/templates/releases/terraform-1.2.tar.gz", Path: "/child", SourceAuth: auth},
+			expectedFiles: map[string]string{
+				"versions.tf": "terraform {}",
+			},
+		},
+		{
+			name:   "unpacks zip object",
+			source: types.Source{URL: server.URL + "/templates/releases/vscode-1.0.zip", SourceAuth: auth},
+			expectedFiles: map[string]string{
+				".vscode/settings.json": "{}",
+			},
+		},
+		{
+			name:   "archive entries cannot escape the filesystem root",
+			source: types.Source{URL: server.URL + "/templates/releases/evil.tar.gz", SourceAuth: auth},
+			expectedFiles: map[string]string{
+				"escape.txt": "nope",
+			},
+		},
+		{
+			name:        "returns error when prefix has no objects",
+			source:      types.Source{URL: server.URL + "/templates/python", SourceAuth: auth},
+			expectedErr: &storage.BlobError{},
+		},
+		{
+			name:        "returns error when credentials are rejected",
+			source:      types.Source{URL: server.URL + "/templates/go/web"},
+			expectedErr: &storage.BlobResponseError{},
+		},
+		{
+			name:        "returns error for unsupported scheme",
+			source:      types.Source{URL: "ftp://example.com/templates", SourceAuth: auth},
+			expectedErr: &storage.BlobError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			t.Setenv("AWS_ACCESS_KEY_ID", "")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+			bc := storage.NewBlobClient()
+			bc.HTTPClient = server.Client()
+			tt.source.SourceType = types.BlobSourceType
+			tt.source.Alias = "blob"
+			bc.SetSource(&tt.source)
+
+			// Act
+			bfs, err := bc.Clone(t.Context())
+
+			// Assert
+			switch e := tt.expectedErr.(type) {
+			case *storage.BlobError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.BlobResponseError:
+				require.ErrorAs(t, err, &e)
+				assert.Equal(t, http.StatusForbidden, e.StatusCode)
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+			for _, p := range tt.absentFiles {
+				_, e := bfs.Stat(p)
+				require.Error(t, e)
+			}
+		})
+	}
+}
+
+// fakeAzureBlob is a minimal path-style Azure Blob endpoint, as served by Azurite, for a single container.
+type fakeAzureBlob struct {
+	account   string
+	container string
+	blobs     map[string][]byte
+	// pageSize forces pagination of listings so continuation markers are exercised.
+	pageSize int
+}
+
+func (f *fakeAzureBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sas := r.URL.Query().Get("sig") == "fakesignature"
+	sharedKey := strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey "+f.account+":")
+	if !sas && !sharedKey {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Error><Code>AuthorizationFailure</Code><Message>denied</Message></Error>`))
+		return
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(segments) < 2 || segments[0] != f.account || segments[1] != f.container {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>ContainerNotFound</Code><Message>no container</Message></Error>`))
+		return
+	}
+
+	if r.URL.Query().Get("comp") == "list" {
+		f.list(w, r)
+		return
+	}
+
+	content, ok := f.blobs[segments[2]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>BlobNotFound</Code><Message>no blob</Message></Error>`))
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+func (f *fakeAzureBlob) list(w http.ResponseWriter, r *http.Request) {
+	type blob struct {
+		Name string `xml:"Name"`
+	}
+	type result struct {
+		XMLName    xml.Name `xml:"EnumerationResults"`
+		Blobs      []blob   `xml:"Blobs>Blob"`
+		NextMarker string   `xml:"NextMarker"`
+	}
+
+	var names []string
+	for n := range f.blobs {
+		if strings.HasPrefix(n, r.URL.Query().Get("prefix")) && n > r.URL.Query().Get("marker") {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	res := result{}
+	if len(names) > f.pageSize {
+		names = names[:f.pageSize]
+		res.NextMarker = names[len(names)-1]
+	}
+	for _, n := range names {
+		res.Blobs = append(res.Blobs, blob{Name: n})
+	}
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func TestBlobClient_CloneAzure(t *testing.T) {
+	// Arrange
+	fake := &fakeAzureBlob{
+		account:   "devstoreaccount1",
+		container: "releases",
+		blobs: map[string][]byte{
+			"vscode/terraform/settings.json":   []byte(`{"editor.formatOnSave": true}`),
+			"vscode/terraform/extensions.json": []byte(`{"recommendations": []}`),
+			"vscode/go/settings.json":          []byte(`{}`),
+			"docs-1.0.tgz":                     buildTarGz(t, map[string]string{"docs/README.md": "# docs"}),
+		},
+		pageSize: 1,
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	containerURL := server.URL + "/devstoreaccount1/releases"
+
+	tests := []struct {
+		name          string
+		source        types.Source
+		expectError   bool
+		expectedFiles map[string]string
+	}{
+		{
+			name: "fetches blobs under prefix with sas token",
+			source: types.Source{
+				URL:        containerURL + "/vscode/terraform",
+				Provider:   types.AzureBlobProvider,
+				SourceAuth: &types.SourceAuth{AuthAlias: "azurite", Token: "?sv=2021-08-06&sp=rl&sig=fakesignature"},
+			},
+			expectedFiles: map[string]string{
+				"settings.json":   `{"editor.formatOnSave": true}`,
+				"extensions.json": `{"recommendations": []}`,
+			},
+		},
+		{
+			name: "fetches blobs with shared key",
+			source: types.Source{
+				URL:      containerURL + "/vscode",
+				Path:     "/go",
+				Provider: types.AzureBlobProvider,
+				SourceAuth: &types.SourceAuth{
+					AuthAlias: "azurite",
+					// Azurite's well known development account key.
+					Key: "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+				},
+			},
+			expectedFiles: map[string]string{
+				"settings.json": `{}`,
+			},
+		},
+		{
+			name: "unpacks archive blob",
+			source: types.Source{
+				URL:        containerURL + "/docs-1.0.tgz",
+				Provider:   types.AzureBlobProvider,
+				SourceAuth: &types.SourceAuth{AuthAlias: "azurite", Token: "sv=2021-08-06&sp=rl&sig=fakesignature"},
+			},
+			expectedFiles: map[string]string{
+				"docs/README.md": "# docs",
+			},
+		},
+		{
+			name: "returns error without credentials",
+			source: types.Source{
+				URL:      containerURL + "/vscode",
+				Provider: types.AzureBlobProvider,
+			},
+			expectError: true,
+		},
+		{
+			name: "returns error for malformed shared key",
+			source: types.Source{
+				URL:        containerURL + "/vscode",
+				Provider:   types.AzureBlobProvider,
+				SourceAuth: &types.SourceAuth{AuthAlias: "azurite", Key: "not base64!"},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			t.Setenv("AZURE_STORAGE_KEY", "")
+			t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+			bc := storage.NewBlobClient()
+			bc.HTTPClient = server.Client()
+			tt.source.SourceType = types.BlobSourceType
+			tt.source.Alias = "azure"
+			bc.SetSource(&tt.source)
+
+			// Act
+			bfs, err := bc.Clone(t.Context())
+
+			// Assert
+			if tt.expectError {
+				var blobErr *storage.BlobError
+				require.ErrorAs(t, err, &blobErr)
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/spf13/afero"
+)
+
+const (
+	cacheEntryFile  = "entry.json"
+	cacheContentDir = "content"
+)
+
+// CacheEntry describes the content of a source held in a SourceCache.
+type CacheEntry struct {
+	Key         string           `json:"-"`
+	Alias       string           `json:"alias"`
+	SourceType  types.SourceType `json:"sourceType"`
+	URL         string           `json:"url"`
+	Ref         string           `json:"ref"`
+	Path        string           `json:"path"`
+	ResolvedRef string           `json:"resolvedRef"`
+	Size        int64            `json:"size"`
+	FetchedAt   time.Time        `json:"fetchedAt"`
+}
+
+/*
+SourceCache keeps fetched source content on disk under Root, with one entry per source URL, resolved ref
+and path. Each entry is a directory named by CacheKey holding the content and an entry.json describing it.
+Entries hold only the content under Source.Path, as it is fetched, so sources that share a repository but
+not a path are cached separately.
+*/
+type SourceCache struct {
+	Fs   afero.Fs
+	Root string
+	now  func() time.Time
+}
+
+// NewSourceCache creates a new SourceCache rooted at root on fs.
+func NewSourceCache(fs afero.Fs, root string) *SourceCache {
+	return &SourceCache{
+		Fs:   fs,
+		Root: root,
+		now:  time.Now,
+	}
+}
+
+// CacheKey returns the key of the entry for a source URL, resolved ref and path.
+func CacheKey(url, resolvedRef, sourcePath string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + resolvedRef + "\x00" + cleanSourcePath(sourcePath)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached content of url at resolvedRef under sourcePath, or a CacheMissError when there is none.
+func (c *SourceCache) Get(url, resolvedRef, sourcePath string) (billy.Filesystem, error) {
+	key := CacheKey(url, resolvedRef, sourcePath)
+	if _, err := c.readEntry(key); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &CacheMissError{URL: url, Ref: resolvedRef, Path: sourcePath}
+		}
+		return nil, err
+	}
+
+	return c.load(key)
+}
+
+/*
+Latest returns the most recently fetched content of url under sourcePath for ref as it is written on the
+source, whatever it resolved to at the time, or a CacheMissError when there is none. It serves sources
+when the remote can't be reached to resolve the ref.
+*/
+func (c *SourceCache) Latest(url, ref, sourcePath string) (billy.Filesystem, error) {
+	sourcePath = cleanSourcePath(sourcePath)
+
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *CacheEntry
+	for i, e := range entries {
+		if e.URL == url && e.Ref == ref && cleanSourcePath(e.Path) == sourcePath && (latest == nil || e.FetchedAt.After(latest.FetchedAt)) {
+			latest = &entries[i]
+		}
+	}
+	if latest == nil {
+		return nil, &CacheMissError{URL: url, Ref: ref, Path: sourcePath}
+	}
+
+	return c.load(latest.Key)
+}
+
+// List returns every entry in the cache. Directories without a readable entry.json, such as an
+// interrupted Put, are skipped.
+func (c *SourceCache) List() ([]CacheEntry, error) {
+	dirs, err := afero.ReadDir(c.Fs, c.Root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		entry, e := c.readEntry(d.Name())
+		if e != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+/*
+Put stores the content of src as the entry for entry.URL, entry.ResolvedRef and entry.Path, replacing any
+existing one, and returns the entry with its Key, Size and FetchedAt filled in. The content is written next to
+the entry and renamed into place, so readers never see a partial entry. Sources may be private, so the
+cache's directories are only accessible to the user and entry.json only readable by them. Content files
+keep their modes, which the files generated from them take, behind those directories.
+*/
+func (c *SourceCache) Put(entry CacheEntry, src billy.Filesystem) (CacheEntry, error) {
+	entry.Key = CacheKey(entry.URL, entry.ResolvedRef, entry.Path)
+	entry.FetchedAt = c.now().UTC()
+
+	size, err := treeSize(src)
+	if err != nil {
+		return entry, err
+	}
+	entry.Size = size
+
+	if err = c.Fs.MkdirAll(c.Root, 0700); err != nil { //nolint:mnd
+		return entry, err
+	}
+
+	tmp, err := afero.TempDir(c.Fs, c.Root, "."+entry.Key+"-")
+	if err != nil {
+		return entry, err
+	}
+	defer func() { _ = c.Fs.RemoveAll(tmp) }()
+
+	contentDir := filepath.Join(tmp, cacheContentDir)
+	if err = c.Fs.MkdirAll(contentDir, 0700); err != nil { //nolint:mnd
+		return entry, err
+	}
+	sfs := &SafeFs{Fs: c.Fs}
+	if err = sfs.CopyFileSystemSafe(src, "/", contentDir); err != nil {
+		return entry, err
+	}
+
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return entry, err
+	}
+	if err = afero.WriteFile(c.Fs, filepath.Join(tmp, cacheEntryFile), b, 0600); err != nil { //nolint:mnd
+		return entry, err
+	}
+
+	dest := filepath.Join(c.Root, entry.Key)
+	if err = c.Fs.RemoveAll(dest); err != nil {
+		return entry, err
+	}
+	if err = c.Fs.Rename(tmp, dest); err != nil {
+		// Another source with the same URL, ref and path stored the same content first.
+		if _, e := c.readEntry(entry.Key); e == nil {
+			return entry, nil
+		}
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+/*
+Prune removes entries fetched more than olderThan ago, then the oldest remaining entries until the
+cache fits within maxSize bytes. Either limit is ignored when zero. It returns the removed entries.
+*/
+func (c *SourceCache) Prune(olderThan time.Duration, maxSize int64) ([]CacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FetchedAt.Before(entries[j].FetchedAt)
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	cutoff := c.now().Add(-olderThan)
+
+	var removed []CacheEntry
+	for _, e := range entries {
+		expired := olderThan > 0 && e.FetchedAt.Before(cutoff)
+		overBudget := maxSize > 0 && total > maxSize
+		if !expired && !overBudget {
+			continue
+		}
+
+		if err = c.Remove(e.Key); err != nil {
+			return removed, err
+		}
+		total -= e.Size
+		removed = append(removed, e)
+	}
+
+	return removed, nil
+}
+
+// Remove deletes the entry with key.
+func (c *SourceCache) Remove(key string) error {
+	return c.Fs.RemoveAll(filepath.Join(c.Root, key))
+}
+
+func (c *SourceCache) readEntry(key string) (CacheEntry, error) {
+	var entry CacheEntry
+
+	b, err := afero.ReadFile(c.Fs, filepath.Join(c.Root, key, cacheEntryFile))
+	if err != nil {
+		return entry, err
+	}
+	if err = json.Unmarshal(b, &entry); err != nil {
+		return entry, err
+	}
+	entry.Key = key
+
+	return entry, nil
+}
+
+// load reads the content of the entry with key into an in-memory filesystem, preserving modes and symlinks.
+func (c *SourceCache) load(key string) (billy.Filesystem, error) {
+	root := filepath.Join(c.Root, key, cacheContentDir)
+	mfs := memfs.New()
+
+	err := afero.Walk(c.Fs, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case info.IsDir():
+			return mfs.MkdirAll(rel, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			reader, ok := c.Fs.(afero.LinkReader)
+			if !ok {
+				return nil
+			}
+			target, e := reader.ReadlinkIfPossible(p)
+			if e != nil {
+				return e
+			}
+			return mfs.Symlink(target, rel)
+		default:
+			f, e := c.Fs.Open(p)
+			if e != nil {
+				return e
+			}
+			defer f.Close()
+			return writeFile(mfs, rel, info.Mode().Perm(), f)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mfs, nil
+}
+
+// treeSize returns the total size of the regular files in fs.
+func treeSize(fs billy.Filesystem) (int64, error) {
+	var size int64
+	err := util.Walk(fs, "/", func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
@@ -0,0 +1,125 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cacheFixture(t *testing.T, files map[string]string) billy.Filesystem {
+	mfs := memfs.New()
+	for p, content := range files {
+		require.NoError(t, util.WriteFile(mfs, p, []byte(content), 0644))
+	}
+	return mfs
+}
+
+func TestSourceCache_PutGet(t *testing.T) {
+	// Arrange
+	cache := storage.NewSourceCache(afero.NewOsFs(), t.TempDir())
+
+	src := cacheFixture(t, map[string]string{
+		"go-web/main.go.template": "package {{.packageName}}",
+	})
+	require.NoError(t, util.WriteFile(src, "scripts/build.sh", []byte("#!/bin/sh"), 0755))
+	require.NoError(t, src.Symlink("go-web/main.go.template", "main.go.template"))
+
+	// Act
+	entry, err := cache.Put(storage.CacheEntry{
+		Alias:       "goWeb",
+		SourceType:  types.GitSourceType,
+		URL:         "https://github.com/onefinedev/templates.git",
+		Ref:         "main",
+		ResolvedRef: "0123abcd",
+	}, src)
+	require.NoError(t, err)
+	bfs, err := cache.Get("https://github.com/onefinedev/templates.git", "0123abcd", "")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, storage.CacheKey("https://github.com/onefinedev/templates.git", "0123abcd", "/"), entry.Key)
+	assert.Equal(t, int64(len("package {{.packageName}}")+len("#!/bin/sh")), entry.Size)
+
+	content, err := util.ReadFile(bfs, "go-web/main.go.template")
+	require.NoError(t, err)
+	assert.Equal(t, "package {{.packageName}}", string(content))
+
+	info, err := bfs.Stat("scripts/build.sh")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	target, err := bfs.Readlink("main.go.template")
+	require.NoError(t, err)
+	assert.Equal(t, "go-web/main.go.template", target)
+
+	entries, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "goWeb", entries[0].Alias)
+	assert.Equal(t, "0123abcd", entries[0].ResolvedRef)
+	assert.False(t, entries[0].FetchedAt.IsZero())
+}
+
+func TestSourceCache_PutPermissions(t *testing.T) {
+	// Arrange
+	root := filepath.Join(t.TempDir(), "cache")
+	cache := storage.NewSourceCache(afero.NewOsFs(), root)
+
+	// Act
+	entry, err := cache.Put(storage.CacheEntry{
+		URL:         "https://github.com/onefinedev/templates.git",
+		Ref:         "main",
+		ResolvedRef: "0123abcd",
+	}, cacheFixture(t, map[string]string{"go-web/main.go.template": "package main"}))
+
+	// Assert
+	require.NoError(t, err)
+	for p, expected := range map[string]os.FileMode{
+		root:                           0700,
+		filepath.Join(root, entry.Key): 0700,
+		filepath.Join(root, entry.Key, "content"):                               0700,
+		filepath.Join(root, entry.Key, "entry.json"):                            0600,
+		filepath.Join(root, entry.Key, "content", "go-web", "main.go.template"): 0644,
+	} {
+		info, statErr := os.Stat(p)
+		require.NoError(t, statErr)
+		assert.Equal(t, expected, info.Mode().Perm(), p)
+	}
+}
+
+func TestSourceCache_Miss(t *testing.T) {
+	// Arrange
+	cache := storage.NewSourceCache(afero.NewOsFs(), t.TempDir())
+	_, err := cache.Put(storage.CacheEntry{
+		URL:         "https://example.com/a.git",
+		Ref:         "main",
+		Path:        "/terraform",
+		ResolvedRef: "0123abcd",
+	}, cacheFixture(t, map[string]string{"a": "a"}))
+	require.NoError(t, err)
+
+	// Act
+	_, getErr := cache.Get("https://example.com/a.git", "4567ef01", "/terraform")
+	_, pathErr := cache.Get("https://example.com/a.git", "0123abcd", "/go-web")
+	_, hitErr := cache.Get("https://example.com/a.git", "0123abcd", "terraform/")
+	_, latestErr := cache.Latest("https://example.com/a.git", "v1.0", "/terraform")
+
+	// Assert
+	var missErr *storage.CacheMissError
+	require.ErrorAs(t, getErr, &missErr)
+	require.ErrorAs(t, pathErr, &missErr)
+	require.NoError(t, hitErr)
+	require.ErrorAs(t, latestErr, &missErr)
+	assert.Equal(t, "v1.0", missErr.Ref)
+}
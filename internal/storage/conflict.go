@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+)
+
+// ConflictPolicy decides how SafeFs resolves a file that more than one source writes.
+type ConflictPolicy struct {
+	// Strategy for files no rule matches, last-wins when empty
+	Strategy types.ConflictStrategy
+
+	// Rules are matched in order against a file's path from its source root, the first match's strategy applies
+	Rules []types.ConflictRule
+}
+
+// Validate checks that the policy only uses known strategies and well formed globs.
+func (p ConflictPolicy) Validate() error {
+	if p.Strategy != "" && !validConflictStrategy(p.Strategy) {
+		return fmt.Errorf("unknown conflict strategy %q", p.Strategy)
+	}
+
+	for _, rule := range p.Rules {
+		if _, err := path.Match(rule.Glob, ""); err != nil {
+			return fmt.Errorf("invalid conflict glob %q: %w", rule.Glob, err)
+		}
+		if !validConflictStrategy(rule.Strategy) {
+			return fmt.Errorf("unknown conflict strategy %q for glob %q", rule.Strategy, rule.Glob)
+		}
+		if rule.Merge != "" && mergeHandler(rule.Merge) == nil {
+			return fmt.Errorf("unknown merge format %q for glob %q", rule.Merge, rule.Glob)
+		}
+	}
+
+	return nil
+}
+
+// rule returns the first rule matching name, a file's path from its source root, or one holding the default strategy.
+func (p ConflictPolicy) rule(name string) types.ConflictRule {
+	for _, rule := range p.Rules {
+		if matchGlob(rule.Glob, name) {
+			return rule
+		}
+	}
+
+	if p.Strategy == "" {
+		return types.ConflictRule{Strategy: types.LastWinsConflictStrategy}
+	}
+	return types.ConflictRule{Strategy: p.Strategy}
+}
+
+// strategy returns the strategy for name, a file's path from its source root.
+func (p ConflictPolicy) strategy(name string) types.ConflictStrategy {
+	return p.rule(name).Strategy
+}
+
+/*
+merger returns the MergeFunc for name, a file's path from its source root: the handler for the merge
+format of the rule matching name, or when it sets none the handler for the file's type. JSON and YAML
+files are deep merged, ignore files and .editorconfig are merged line by line, and anything else is
+concatenated.
+*/
+func (p ConflictPolicy) merger(name string) MergeFunc {
+	format := p.rule(name).Merge
+	if format == "" {
+		format = mergeFormat(name)
+	}
+	return mergeHandler(format)
+}
+
+func validConflictStrategy(strategy types.ConflictStrategy) bool {
+	switch strategy {
+	case types.ErrorConflictStrategy,
+		types.FirstWinsConflictStrategy,
+		types.LastWinsConflictStrategy,
+		types.SkipConflictStrategy,
+		types.MergeConflictStrategy:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchGlob reports whether name matches glob, comparing only the file name when glob has no /.
+func matchGlob(glob, name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+	} else {
+		name = path.Base(name)
+	}
+
+	ok, _ := path.Match(glob, name)
+	return ok
+}
+
+// Conflict is a file written by more than one source, and the strategy that resolved it.
+type Conflict struct {
+	Path     string
+	Sources  []string
+	Strategy types.ConflictStrategy
+}
+
+// MergeFunc merges incoming, a later source's copy of the file at name, into existing, its content so far.
+type MergeFunc func(name string, existing, incoming []byte) ([]byte, error)
@@ -1,6 +1,17 @@
 package storage
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"syscall"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
 
 type TransportAuthMismatchError struct {
 	URL                string
@@ -11,6 +22,33 @@ func (e *TransportAuthMismatchError) Error() string {
 	return fmt.Sprintf("mismatched or no auth method (%s) for url: %s", e.ExpectedAuthMethod, e.URL)
 }
 
+type GitURLError struct {
+	URL   string
+	OpErr error
+}
+
+func (e *GitURLError) Error() string {
+	return fmt.Sprintf("invalid git url %s: %s", e.URL, e.OpErr)
+}
+
+func (e *GitURLError) Unwrap() error {
+	return e.OpErr
+}
+
+type CredentialHelperError struct {
+	URL    string
+	Helper string
+	OpErr  error
+}
+
+func (e *CredentialHelperError) Error() string {
+	return fmt.Sprintf("failed to get credentials for %s from %s credential helper: %s", e.URL, e.Helper, e.OpErr)
+}
+
+func (e *CredentialHelperError) Unwrap() error {
+	return e.OpErr
+}
+
 type SSHKeyError struct {
 	SSHKeyPath string
 	OpErr      error
@@ -19,3 +57,201 @@ type SSHKeyError struct {
 func (e *SSHKeyError) Error() string {
 	return fmt.Sprintf("failed to create ssh auth for %s: %s", e.SSHKeyPath, e.OpErr)
 }
+
+type HostKeyError struct {
+	Host           string
+	KnownHostsPath string
+	OpErr          error
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Host == "" {
+		return fmt.Sprintf("failed to load known hosts from %s: %s", e.KnownHostsPath, e.OpErr)
+	}
+	return fmt.Sprintf("failed to verify host key for %s against %s: %s", e.Host, e.KnownHostsPath, e.OpErr)
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.OpErr
+}
+
+type SourcePathError struct {
+	SourcePath string
+	OpErr      error
+}
+
+func (e *SourcePathError) Error() string {
+	return fmt.Sprintf("failed to read source path %s: %s", e.SourcePath, e.OpErr)
+}
+
+func (e *SourcePathError) Unwrap() error {
+	return e.OpErr
+}
+
+// UnsafeSymlinkError is a symlink whose target resolves outside of the source or directory it is created in.
+type UnsafeSymlinkError struct {
+	Path   string
+	Target string
+}
+
+func (e *UnsafeSymlinkError) Error() string {
+	return fmt.Sprintf("symlink %s -> %s resolves outside of its root", e.Path, e.Target)
+}
+
+type FileConflictError struct {
+	Path    string
+	Sources []string
+}
+
+func (e *FileConflictError) Error() string {
+	return fmt.Sprintf("conflicting copies of %s from sources: %s", e.Path, strings.Join(e.Sources, ", "))
+}
+
+type MergeError struct {
+	Path  string
+	OpErr error
+}
+
+func (e *MergeError) Error() string {
+	return fmt.Sprintf("failed to merge %s: %s", e.Path, e.OpErr)
+}
+
+func (e *MergeError) Unwrap() error {
+	return e.OpErr
+}
+
+type ArchiveError struct {
+	Name  string
+	OpErr error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("failed to unpack archive %s: %s", e.Name, e.OpErr)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.OpErr
+}
+
+type BlobError struct {
+	URL   string
+	OpErr error
+}
+
+func (e *BlobError) Error() string {
+	return fmt.Sprintf("failed to fetch blob source %s: %s", e.URL, e.OpErr)
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.OpErr
+}
+
+type BlobResponseError struct {
+	StatusCode int
+	Key        string
+	Code       string
+	Message    string
+}
+
+func (e *BlobResponseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("unexpected status %d for object %q", e.StatusCode, e.Key)
+	}
+	return fmt.Sprintf("unexpected status %d for object %q: %s: %s", e.StatusCode, e.Key, e.Code, e.Message)
+}
+
+type ChecksumMismatchError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("sha256 mismatch for %s: expected %s, got %s", e.URL, e.Expected, e.Actual)
+}
+
+type OCIError struct {
+	Reference string
+	OpErr     error
+}
+
+func (e *OCIError) Error() string {
+	return fmt.Sprintf("failed to pull oci artifact %s: %s", e.Reference, e.OpErr)
+}
+
+func (e *OCIError) Unwrap() error {
+	return e.OpErr
+}
+
+type GitRefError struct {
+	URL   string
+	Ref   string
+	OpErr error
+}
+
+func (e *GitRefError) Error() string {
+	return fmt.Sprintf("failed to resolve ref %s for %s: %s", e.Ref, e.URL, e.OpErr)
+}
+
+func (e *GitRefError) Unwrap() error {
+	return e.OpErr
+}
+
+type CacheMissError struct {
+	URL  string
+	Ref  string
+	Path string
+}
+
+func (e *CacheMissError) Error() string {
+	if e.Path == "" || e.Path == "/" {
+		return fmt.Sprintf("no cached content for %s at ref %q", e.URL, e.Ref)
+	}
+	return fmt.Sprintf("no cached content for %s at ref %q under %s", e.URL, e.Ref, e.Path)
+}
+
+/*
+IsTransient reports whether err is a failure that may not happen again when the operation is retried:
+a timeout, a dropped or refused connection, or a server that is busy or failing (429 or 5xx). Errors
+from a cancelled context, auth failures and anything else that a retry would only repeat are not.
+*/
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var responseErr *BlobResponseError
+	if errors.As(err, &responseErr) {
+		return isTransientStatus(responseErr.StatusCode)
+	}
+
+	var httpErr *http.Err
+	if errors.As(err, &httpErr) && httpErr.Response != nil {
+		return isTransientStatus(httpErr.Response.StatusCode)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+func isTransientStatus(statusCode int) bool {
+	return statusCode == nethttp.StatusTooManyRequests || statusCode >= nethttp.StatusInternalServerError
+}
@@ -0,0 +1,118 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "nil",
+			err:      nil,
+			expected: false,
+		},
+		{
+			name:     "cancelled context",
+			err:      fmt.Errorf("fetch: %w", context.Canceled),
+			expected: false,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      fmt.Errorf("fetch: %w", context.DeadlineExceeded),
+			expected: true,
+		},
+		{
+			name:     "unexpected eof",
+			err:      io.ErrUnexpectedEOF,
+			expected: true,
+		},
+		{
+			name: "connection refused",
+			err: &storage.BlobError{
+				URL:   "s3://bucket/key",
+				OpErr: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			},
+			expected: true,
+		},
+		{
+			name:     "connection reset",
+			err:      &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			expected: true,
+		},
+		{
+			name:     "temporary dns failure",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "example.com", IsTemporary: true}},
+			expected: true,
+		},
+		{
+			name:     "unknown host",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "example.invalid", IsNotFound: true}},
+			expected: false,
+		},
+		{
+			name:     "blob server error",
+			err:      &storage.BlobError{URL: "s3://bucket/key", OpErr: &storage.BlobResponseError{StatusCode: nethttp.StatusBadGateway}},
+			expected: true,
+		},
+		{
+			name:     "blob throttled",
+			err:      &storage.BlobResponseError{StatusCode: nethttp.StatusTooManyRequests},
+			expected: true,
+		},
+		{
+			name:     "blob not found",
+			err:      &storage.BlobResponseError{StatusCode: nethttp.StatusNotFound},
+			expected: false,
+		},
+		{
+			name:     "git server error",
+			err:      &http.Err{Response: &nethttp.Response{StatusCode: nethttp.StatusServiceUnavailable}},
+			expected: true,
+		},
+		{
+			name:     "git authentication required",
+			err:      transport.ErrAuthenticationRequired,
+			expected: false,
+		},
+		{
+			name:     "checksum mismatch",
+			err:      &storage.ChecksumMismatchError{URL: "https://example.com/a.tar.gz"},
+			expected: false,
+		},
+		{
+			name:     "other error",
+			err:      errors.New("boom"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			transient := storage.IsTransient(tt.err)
+
+			// Assert
+			assert.Equal(t, tt.expected, transient)
+		})
+	}
+}
@@ -2,11 +2,19 @@ package storage
 
 import (
 	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 )
 
+const fileURLScheme = "file://"
+
 type FileClient struct {
 	CurrentSource *types.FileSource
 }
@@ -16,12 +24,203 @@ func NewFileClient() *FileClient {
 	return &FileClient{}
 }
 
-func (fc *FileClient) Clone(_ context.Context) (billy.Filesystem, error) {
-	// TODO: Implement git storage source cloning/downloading
-	return nil, ErrNotImplemented
+/*
+Clone reads the directory referenced by the current source into an in-memory filesystem.
+When URL is set it is used as the root directory and Path is treated as a sub-root within it,
+mirroring GitClient. When URL is empty, Path is the directory to read. Both support a leading
+~ and environment variable expansion. Symlinks that resolve within the root are preserved as
+symlinks, those that point outside of it are dereferenced and their content copied.
+*/
+func (fc *FileClient) Clone(ctx context.Context) (billy.Filesystem, error) {
+	root, err := fc.rootPath()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, &SourcePathError{SourcePath: root, OpErr: err}
+	}
+	if !info.IsDir() {
+		return nil, &SourcePathError{SourcePath: root, OpErr: errNotADirectory}
+	}
+
+	mfs := memfs.New()
+
+	l := &dirLoader{
+		ctx:     ctx,
+		root:    root,
+		dest:    mfs,
+		visited: map[string]bool{},
+	}
+
+	if err = l.load(root, "/"); err != nil {
+		return nil, &SourcePathError{SourcePath: root, OpErr: err}
+	}
+
+	return mfs, nil
 }
 
 // SetSource sets the current source.
 func (fc *FileClient) SetSource(s *types.Source) {
 	fc.CurrentSource = (*types.FileSource)(s)
 }
+
+// rootPath resolves the absolute directory that should be read for the current source.
+func (fc *FileClient) rootPath() (string, error) {
+	base := fc.CurrentSource.URL
+	sub := fc.CurrentSource.Path
+
+	if base == "" {
+		base, sub = sub, ""
+	}
+	base = strings.TrimPrefix(base, fileURLScheme)
+
+	if base == "" {
+		return "", &SourcePathError{OpErr: errNoSourcePath}
+	}
+
+	expanded, err := ExpandPath(base)
+	if err != nil {
+		return "", &SourcePathError{SourcePath: base, OpErr: err}
+	}
+
+	return filepath.Abs(filepath.Join(expanded, sub))
+}
+
+// ExpandPath expands environment variables and a leading ~ in p.
+func ExpandPath(p string) (string, error) {
+	p = os.ExpandEnv(p)
+
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, strings.TrimPrefix(p, "~")), nil
+}
+
+// dirLoader copies a directory tree on the local disk into a billy filesystem.
+type dirLoader struct {
+	ctx     context.Context //nolint:containedctx // scoped to a single Clone call
+	root    string
+	dest    billy.Filesystem
+	visited map[string]bool
+}
+
+// load copies the contents of the directory at osPath into memPath in the destination filesystem.
+func (l *dirLoader) load(osPath string, memPath string) error {
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+
+	realPath, err := filepath.EvalSymlinks(osPath)
+	if err != nil {
+		return err
+	}
+	// Guards against symlink cycles when dereferencing directories outside of the root.
+	if l.visited[realPath] {
+		return nil
+	}
+	l.visited[realPath] = true
+	defer delete(l.visited, realPath)
+
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return err
+	}
+
+	if err = l.dest.MkdirAll(memPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(osPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() == ".git" {
+			continue
+		}
+
+		entryOsPath := filepath.Join(osPath, entry.Name())
+		entryMemPath := filepath.Join(memPath, entry.Name())
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			err = l.loadSymlink(entryOsPath, entryMemPath)
+		case entry.IsDir():
+			err = l.load(entryOsPath, entryMemPath)
+		case entry.Type().IsRegular():
+			err = l.loadFile(entryOsPath, entryMemPath)
+		default:
+			// Sockets, devices and pipes have no meaning in a template source.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSymlink preserves a symlink that resolves inside the root, and dereferences one that does not.
+func (l *dirLoader) loadSymlink(osPath string, memPath string) error {
+	target, err := os.Readlink(osPath)
+	if err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(target) && withinRoot(l.root, filepath.Join(filepath.Dir(osPath), target)) {
+		return l.dest.Symlink(target, memPath)
+	}
+
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return l.load(osPath, memPath)
+	}
+
+	return l.loadFile(osPath, memPath)
+}
+
+// loadFile copies a single file, preserving its permission bits.
+func (l *dirLoader) loadFile(osPath string, memPath string) error {
+	src, err := os.Open(osPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := l.dest.OpenFile(memPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// withinRoot reports whether p is root or a descendant of it.
+func withinRoot(root string, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
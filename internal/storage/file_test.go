@@ -0,0 +1,152 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupFixtureSourceDir lays out a template source directory on disk and returns its path.
+func setupFixtureSourceDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "terraform", "modules"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md.template"), []byte("# {{.projectName}}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "terraform", "main.tf"), []byte(`module "x" {}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "terraform", "init.sh"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Symlink("main.tf", filepath.Join(dir, "terraform", "link.tf")))
+
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "shared.tf"), []byte("shared"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "shared.tf"), filepath.Join(dir, "terraform", "shared.tf")))
+
+	return dir
+}
+
+func TestFileClient_Clone(t *testing.T) {
+	// Arrange
+	fixtureDir := setupFixtureSourceDir(t)
+
+	tests := []struct {
+		name          string
+		source        types.Source
+		env           map[string]string
+		expectedErr   bool
+		expectedFiles map[string]string
+	}{
+		{
+			name:   "reads directory from path",
+			source: types.Source{SourceType: types.FileSourceType, Alias: "local", Path: fixtureDir},
+			expectedFiles: map[string]string{
+				"README.md.template":  "# {{.projectName}}",
+				"terraform/main.tf":   `module "x" {}`,
+				"terraform/shared.tf": "shared",
+			},
+		},
+		{
+			name: "url is root and path is sub-root",
+			source: types.Source{
+				SourceType: types.FileSourceType,
+				Alias:      "local",
+				URL:        "file://" + fixtureDir,
+				Path:       "/terraform",
+			},
+			expectedFiles: map[string]string{
+				"main.tf": `module "x" {}`,
+				"link.tf": `module "x" {}`,
+			},
+		},
+		{
+			name:   "expands environment variables",
+			source: types.Source{SourceType: types.FileSourceType, Alias: "local", Path: "$TMPLTR_TEST_SOURCE/terraform"},
+			env:    map[string]string{"TMPLTR_TEST_SOURCE": fixtureDir},
+			expectedFiles: map[string]string{
+				"main.tf": `module "x" {}`,
+			},
+		},
+		{
+			name:   "expands home directory",
+			source: types.Source{SourceType: types.FileSourceType, Alias: "local", Path: "~/terraform"},
+			env:    map[string]string{"HOME": fixtureDir},
+			expectedFiles: map[string]string{
+				"main.tf": `module "x" {}`,
+			},
+		},
+		{
+			name:        "returns error when directory does not exist",
+			source:      types.Source{SourceType: types.FileSourceType, Alias: "local", Path: filepath.Join(fixtureDir, "nope")},
+			expectedErr: true,
+		},
+		{
+			name:        "returns error when path is a file",
+			source:      types.Source{SourceType: types.FileSourceType, Alias: "local", Path: filepath.Join(fixtureDir, "README.md.template")},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fc := storage.NewFileClient()
+			fc.SetSource(&tt.source)
+
+			// Act
+			bfs, err := fc.Clone(t.Context())
+
+			// Assert
+			if tt.expectedErr {
+				require.Error(t, err)
+				var pathErr *storage.SourcePathError
+				require.ErrorAs(t, err, &pathErr)
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+			_, err = bfs.Stat(".git")
+			require.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestFileClient_ClonePreservesModesAndSymlinks(t *testing.T) {
+	// Arrange
+	fixtureDir := setupFixtureSourceDir(t)
+	fc := storage.NewFileClient()
+	fc.SetSource(&types.Source{SourceType: types.FileSourceType, Alias: "local", Path: fixtureDir})
+
+	// Act
+	bfs, err := fc.Clone(t.Context())
+
+	// Assert
+	require.NoError(t, err)
+
+	info, err := bfs.Stat("terraform/init.sh")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	target, err := bfs.Readlink("terraform/link.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "main.tf", target)
+
+	// Links that escape the source root are dereferenced rather than preserved.
+	info, err = bfs.Lstat("terraform/shared.tf")
+	require.NoError(t, err)
+	assert.Zero(t, info.Mode()&os.ModeSymlink)
+}
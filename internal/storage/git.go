@@ -2,19 +2,64 @@ package storage
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
+	gossh "golang.org/x/crypto/ssh"
 )
 
+const (
+	gitProtocolSSH   = "ssh"
+	gitProtocolHTTP  = "http"
+	gitProtocolHTTPS = "https"
+	gitProtocolGit   = "git"
+	gitProtocolFile  = "file"
+)
+
+const (
+	envDefaultSSHKeyPath       = "TMLPTR_DEFAULT_SSH_KEY_PATH"
+	envDefaultSSHKeyPassphrase = "TMLPTR_DEFAULT_SSH_KEY_PASSPHRASE"
+	envSSHAuthSock             = "SSH_AUTH_SOCK"
+)
+
+// defaultKnownHostsPath is the known_hosts file used for sources that don't set KnownHostsPath.
+const defaultKnownHostsPath = "~/.ssh/known_hosts"
+
+// defaultSSHPort is the port for ssh URLs that don't name one.
+const defaultSSHPort = 22
+
+// defaultSSHUser is the user for ssh URLs that don't name one, as used by every major git host.
+const defaultSSHUser = "git"
+
+// pinnedRef is the local ref a commit fetched by SHA is stored under.
+const pinnedRef = "refs/heads/tmpltr-pinned"
+
+// minAbbrevHashLen is the shortest abbreviated commit SHA accepted as a ref, matching git's own minimum.
+const minAbbrevHashLen = 4
+
 func init() { //nolint:gochecknoinits // needed
 	transport.UnsupportedCapabilities = []capability.Capability{ //nolint:reassign // needed
 		capability.ThinPack,
@@ -23,79 +68,676 @@ func init() { //nolint:gochecknoinits // needed
 
 type GitClient struct {
 	CurrentSource *types.GitSource
+
+	// PassphrasePrompt asks for the passphrase of an encrypted ssh key when none is configured.
+	PassphrasePrompt func(keyPath string) (string, error)
+
+	// filled holds credentials from a credential helper by URL, so resolving and cloning a source asks once.
+	filled map[string]*http.BasicAuth
+
+	// prompted holds passphrases from PassphrasePrompt by key path, so resolving and cloning a source asks once.
+	prompted map[string]string
 }
 
 func NewGitClient() *GitClient {
 	return &GitClient{}
 }
 
+/*
+Clone fetches the source repository and returns the files under Source.Path in an in-memory filesystem
+rooted at that path, see Fetch. The fetch's temporary directory is removed before it returns.
+*/
 func (gc *GitClient) Clone(ctx context.Context) (billy.Filesystem, error) {
+	snapshot, err := gc.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+
+	return snapshot.Path(gc.CurrentSource.Path)
+}
+
+/*
+Fetch fetches the source's ref, or the remote HEAD when no ref is set, into a temporary directory on disk
+from which the files under any path can then be read. Nothing is checked out as a whole, only the trees
+and blobs under a path are read into memory when it is asked for, so memory scales with the paths rather
+than the repository. The transport and auth are picked from the URL, see auth.
+
+Only memory is bounded by the paths. Fetch time, bandwidth and disk use are not: the whole tree of the
+commit is downloaded at depth 1, as go-git can neither ask the server for a partial clone filter nor
+fetch the blobs such a filter leaves out, and its packfile is kept in a tmpltr-git-* directory under the
+OS temp directory until the snapshot is closed. A commit the server won't serve on its own takes every
+branch and tag with full history, see fetchCommit. The directory is removed when Fetch fails.
+*/
+func (gc *GitClient) Fetch(ctx context.Context) (_ types.SourceSnapshot, err error) {
+	gitAuth, err := gc.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "tmpltr-git-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	stg := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+
+	var repo *git.Repository
+	if gc.CurrentSource.Ref != "" {
+		repo, err = gc.cloneRef(ctx, stg, gitAuth)
+	} else {
+		repo, err = git.CloneContext(ctx, stg, nil, &git.CloneOptions{
+			URL:          gc.CurrentSource.URL,
+			Auth:         gitAuth,
+			Depth:        1,
+			SingleBranch: true,
+		})
+	}
+	gc.settleCredentials(ctx, gitAuth, err)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := repo.ResolveRevision(plumbing.Revision(plumbing.HEAD))
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitSnapshot{repo: repo, dir: dir, head: *head}, nil
+}
+
+// gitSnapshot is a repository fetched by GitClient.Fetch, checked out at head.
+type gitSnapshot struct {
+	repo *git.Repository
+	dir  string
+	head plumbing.Hash
+}
+
+// Revision returns the commit the snapshot holds.
+func (s *gitSnapshot) Revision() string {
+	return s.head.String()
+}
+
+/*
+Path writes the files under p at the repository's HEAD into an in-memory filesystem rooted at p,
+preserving executable bits and symlinks. A path that isn't a directory in the commit, or holds a symlink
+that resolves outside of it, see symlinkInRoot, is reported as a SourcePathError.
+*/
+func (s *gitSnapshot) Path(p string) (billy.Filesystem, error) {
+	commit, err := s.repo.CommitObject(s.head)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	if cleaned := cleanSourcePath(p); cleaned != "" {
+		tree, err = tree.Tree(cleaned)
+		if err != nil {
+			return nil, &SourcePathError{SourcePath: p, OpErr: err}
+		}
+	}
+
+	// Every link is known before any is checked, so each is resolved through all the others.
+	links := make(map[string]string)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode != filemode.Symlink {
+			return nil
+		}
+		target, e := f.Contents()
+		links[f.Name] = target
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(links)) {
+		if err = symlinkInRoot(links, name, links[name]); err != nil {
+			return nil, &SourcePathError{SourcePath: p, OpErr: err}
+		}
+	}
+
 	mfs := memfs.New()
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink {
+			if e := mfs.MkdirAll(path.Dir(f.Name), 0755); e != nil { //nolint:mnd
+				return e
+			}
+			return mfs.Symlink(links[f.Name], f.Name)
+		}
 
-	// Check that auth method matches transport
-	isSSHTransport := strings.Contains(gc.CurrentSource.URL, "ssh")
+		perm := os.FileMode(0644) //nolint:mnd
+		if f.Mode == filemode.Executable {
+			perm = 0755 //nolint:mnd
+		}
 
-	if isSSHTransport && gc.CurrentSource.SSHKey == "" {
-		return nil, &TransportAuthMismatchError{
-			ExpectedAuthMethod: "ssh",
-			URL:                gc.CurrentSource.URL,
+		r, e := f.Reader()
+		if e != nil {
+			return e
 		}
+		defer r.Close()
+
+		return writeFile(mfs, f.Name, perm, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mfs, nil
+}
+
+// Close removes the fetched repository from disk.
+func (s *gitSnapshot) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+func (gc *GitClient) SetSource(s *types.Source) {
+	gc.CurrentSource = (*types.GitSource)(s)
+}
+
+/*
+auth builds the transport auth for the source from its URL, which may be a URL or scp-like
+user@host:path syntax. ssh auth is built by sshAuth and http(s) auth by httpAuth. git:// and file://
+take no credentials.
+*/
+func (gc *GitClient) auth(ctx context.Context) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(gc.CurrentSource.URL)
+	if err != nil {
+		return nil, &GitURLError{URL: gc.CurrentSource.URL, OpErr: err}
+	}
+
+	var sourceAuth types.SourceAuth
+	if gc.CurrentSource.SourceAuth != nil {
+		sourceAuth = *gc.CurrentSource.SourceAuth
 	}
 
-	if !isSSHTransport && gc.CurrentSource.Pat == "" {
-		return nil, &TransportAuthMismatchError{
-			ExpectedAuthMethod: "PAT",
-			URL:                gc.CurrentSource.URL,
+	switch ep.Protocol {
+	case gitProtocolSSH:
+		return gc.sshAuth(ep, sourceAuth)
+	case gitProtocolHTTP, gitProtocolHTTPS:
+		return gc.httpAuth(ctx, ep, sourceAuth)
+	case gitProtocolGit, gitProtocolFile:
+		return nil, nil
+	default:
+		return nil, &GitURLError{URL: gc.CurrentSource.URL, OpErr: errUnsupportedGitURL}
+	}
+}
+
+/*
+httpAuth builds http(s) basic auth for the source from UserName and Pat or, when no Pat is set, from the
+credential helper named by CredentialHelper. Credentials are optional so public repositories clone
+anonymously, but credentials of only the ssh kind are reported as a TransportAuthMismatchError.
+*/
+func (gc *GitClient) httpAuth(
+	ctx context.Context,
+	ep *transport.Endpoint,
+	sourceAuth types.SourceAuth,
+) (transport.AuthMethod, error) {
+	if sourceAuth.Pat != "" {
+		return &http.BasicAuth{
+			Username: sourceAuth.UserName,
+			Password: sourceAuth.Pat,
+		}, nil
+	}
+
+	switch sourceAuth.CredentialHelper {
+	case "":
+		if sourceAuth.SSHKey != "" {
+			return nil, &TransportAuthMismatchError{ExpectedAuthMethod: "PAT", URL: gc.CurrentSource.URL}
+		}
+		return nil, nil
+	case types.GitCredentialHelper:
+		if basicAuth, ok := gc.filled[gc.CurrentSource.URL]; ok {
+			return basicAuth, nil
+		}
+
+		basicAuth, err := gitCredentialFill(ctx, ep, sourceAuth.UserName)
+		if err != nil {
+			return nil, &CredentialHelperError{
+				URL:    gc.CurrentSource.URL,
+				Helper: string(sourceAuth.CredentialHelper),
+				OpErr:  err,
+			}
+		}
+
+		if gc.filled == nil {
+			gc.filled = make(map[string]*http.BasicAuth)
+		}
+		gc.filled[gc.CurrentSource.URL] = basicAuth
+		return basicAuth, nil
+	default:
+		return nil, &CredentialHelperError{
+			URL:    gc.CurrentSource.URL,
+			Helper: string(sourceAuth.CredentialHelper),
+			OpErr:  errUnsupportedCredentialHelper,
 		}
 	}
+}
+
+/*
+settleCredentials reports the outcome err of using gitAuth to git's credential helpers when it came from
+them, see gitCredentialSettle, and forgets credentials the remote refused so they are asked for again. The
+helpers failing to take the report doesn't fail the fetch.
+*/
+func (gc *GitClient) settleCredentials(ctx context.Context, gitAuth transport.AuthMethod, err error) {
+	basicAuth, ok := gitAuth.(*http.BasicAuth)
+	if !ok || basicAuth == nil || gc.filled[gc.CurrentSource.URL] != basicAuth {
+		return
+	}
+
+	ep, epErr := transport.NewEndpoint(gc.CurrentSource.URL)
+	if epErr != nil {
+		return
+	}
+	_ = gitCredentialSettle(ctx, ep, basicAuth, err)
+
+	if isAuthFailure(err) {
+		delete(gc.filled, gc.CurrentSource.URL)
+	}
+}
+
+/*
+sshAuth builds ssh auth for the source. The key at TMLPTR_DEFAULT_SSH_KEY_PATH is used when that is set,
+otherwise the source's SSHKey. Without either, keys are taken from the ssh agent at SSH_AUTH_SOCK.
+An encrypted key is decrypted with the source's SSHKeyPassphrase, TMLPTR_DEFAULT_SSH_KEY_PASSPHRASE or,
+failing both, a passphrase from PassphrasePrompt.
+*/
+func (gc *GitClient) sshAuth(ep *transport.Endpoint, sourceAuth types.SourceAuth) (transport.AuthMethod, error) {
+	user := ep.User
+	if user == "" {
+		user = defaultSSHUser
+	}
+
+	keyPath, pemBytes, passphrase, err := sshKey(sourceAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyPath == "" {
+		if sourceAuth.Pat != "" || os.Getenv(envSSHAuthSock) == "" {
+			return nil, &TransportAuthMismatchError{ExpectedAuthMethod: "ssh", URL: gc.CurrentSource.URL}
+		}
 
-	// build the expected git auth
-	var gitAuth transport.AuthMethod
-	switch isSSHTransport {
-	case true:
-		publicKeys, err := ssh.NewPublicKeysFromFile("git", gc.CurrentSource.SSHKey, "")
+		agentAuth, err := ssh.NewSSHAgentAuth(user)
 		if err != nil {
 			return nil, &SSHKeyError{
-				SSHKeyPath: gc.CurrentSource.SSHKey,
+				SSHKeyPath: os.Getenv(envSSHAuthSock),
 				OpErr:      err,
 			}
 		}
-		gitAuth = publicKeys
-	default:
-		gitAuth = &http.BasicAuth{
-			Username: gc.CurrentSource.UserName,
-			Password: gc.CurrentSource.Pat,
+		callback, algorithms, err := gc.hostKeyCallback(ep)
+		if err != nil {
+			return nil, err
+		}
+		agentAuth.HostKeyCallback = callback
+		return withHostKeyAlgorithms(agentAuth, algorithms), nil
+	}
+
+	if passphrase == "" && gc.PassphrasePrompt != nil {
+		passphrase, err = gc.promptPassphrase(keyPath, pemBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	publicKeys, err := ssh.NewPublicKeys(user, pemBytes, passphrase)
+	if err != nil {
+		// A passphrase that doesn't decrypt the key is asked for again next time.
+		delete(gc.prompted, keyPath)
+		return nil, &SSHKeyError{
+			SSHKeyPath: keyPath,
+			OpErr:      err,
 		}
 	}
 
-	gitOpts := &git.CloneOptions{
-		URL:          gc.CurrentSource.URL,
-		Auth:         gitAuth,
-		Depth:        1,
-		SingleBranch: true,
+	callback, algorithms, err := gc.hostKeyCallback(ep)
+	if err != nil {
+		return nil, err
+	}
+	publicKeys.HostKeyCallback = callback
+
+	return withHostKeyAlgorithms(publicKeys, algorithms), nil
+}
+
+/*
+sshKey returns the path of the ssh key sshAuth uses for sourceAuth, the key and the passphrase set for
+it. The path is empty when keys are to be taken from the ssh agent.
+*/
+func sshKey(sourceAuth types.SourceAuth) (string, []byte, string, error) {
+	keyPath := sourceAuth.SSHKey
+	if p := os.Getenv(envDefaultSSHKeyPath); p != "" {
+		keyPath = p
+	}
+	if keyPath == "" {
+		return "", nil, "", nil
+	}
+
+	keyPath, err := ExpandPath(keyPath)
+	if err != nil {
+		return "", nil, "", &SSHKeyError{SSHKeyPath: keyPath, OpErr: err}
 	}
 
-	stg := memory.NewStorage()
-	_, err := git.CloneContext(ctx, stg, mfs, gitOpts)
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", nil, "", &SSHKeyError{SSHKeyPath: keyPath, OpErr: err}
+	}
+
+	passphrase := sourceAuth.SSHKeyPassphrase
+	if passphrase == "" {
+		passphrase = os.Getenv(envDefaultSSHKeyPassphrase)
+	}
+
+	return keyPath, pemBytes, passphrase, nil
+}
+
+/*
+PromptCredentials asks PassphrasePrompt for the passphrase of the source's ssh key when the key is
+encrypted and no passphrase is set for it. Callers run it ahead of fetching with a timeout, so the time
+taken to answer isn't counted against it, and auth then uses the answer. Problems with the URL or key
+are left for auth to report.
+*/
+func (gc *GitClient) PromptCredentials() error {
+	if gc.PassphrasePrompt == nil {
+		return nil
+	}
+
+	ep, err := transport.NewEndpoint(gc.CurrentSource.URL)
+	if err != nil || ep.Protocol != gitProtocolSSH {
+		return nil //nolint:nilerr // reported by auth
+	}
+
+	var sourceAuth types.SourceAuth
+	if gc.CurrentSource.SourceAuth != nil {
+		sourceAuth = *gc.CurrentSource.SourceAuth
+	}
+
+	keyPath, pemBytes, passphrase, err := sshKey(sourceAuth)
+	if err != nil || keyPath == "" || passphrase != "" {
+		return nil //nolint:nilerr // reported by auth
+	}
+
+	_, err = gc.promptPassphrase(keyPath, pemBytes)
+	return err
+}
+
+// promptPassphrase asks PassphrasePrompt for the passphrase of the key at keyPath, empty when it isn't encrypted.
+func (gc *GitClient) promptPassphrase(keyPath string, pemBytes []byte) (string, error) {
+	if passphrase, ok := gc.prompted[keyPath]; ok {
+		return passphrase, nil
+	}
+
+	var missingErr *gossh.PassphraseMissingError
+	if _, err := gossh.ParsePrivateKey(pemBytes); !errors.As(err, &missingErr) {
+		return "", nil
+	}
+
+	passphrase, err := gc.PassphrasePrompt(keyPath)
+	if err != nil {
+		return "", &SSHKeyError{SSHKeyPath: keyPath, OpErr: err}
+	}
+
+	if gc.prompted == nil {
+		gc.prompted = make(map[string]string)
+	}
+	gc.prompted[keyPath] = passphrase
+	return passphrase, nil
+}
+
+/*
+hostKeyCallback verifies ssh host keys against the source's known_hosts file, ~/.ssh/known_hosts unless
+KnownHostsPath is set. Keys that are unknown, changed or revoked fail the connection with a HostKeyError.
+It also returns the key algorithms known_hosts holds for the host, so the server is asked for a key that
+can be verified. Verification is skipped entirely when the source sets InsecureIgnoreHostKey.
+*/
+func (gc *GitClient) hostKeyCallback(ep *transport.Endpoint) (gossh.HostKeyCallback, []string, error) {
+	if gc.CurrentSource.InsecureIgnoreHostKey {
+		return gossh.InsecureIgnoreHostKey(), nil, nil //nolint:gosec // explicitly opted out on the source
+	}
+
+	knownHostsPath := gc.CurrentSource.KnownHostsPath
+	if knownHostsPath == "" {
+		knownHostsPath = defaultKnownHostsPath
+	}
+
+	expanded, err := ExpandPath(knownHostsPath)
+	if err != nil {
+		return nil, nil, &HostKeyError{KnownHostsPath: knownHostsPath, OpErr: err}
+	}
+	knownHostsPath = expanded
+
+	// NewKnownHostsDb skips files that don't exist, report them rather than its generic error.
+	if _, err = os.Stat(knownHostsPath); err != nil {
+		return nil, nil, &HostKeyError{KnownHostsPath: knownHostsPath, OpErr: err}
+	}
+
+	db, err := ssh.NewKnownHostsDb(knownHostsPath)
+	if err != nil {
+		return nil, nil, &HostKeyError{KnownHostsPath: knownHostsPath, OpErr: err}
+	}
+
+	port := ep.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	algorithms := db.HostKeyAlgorithms(net.JoinHostPort(ep.Host, strconv.Itoa(port)))
+
+	callback := db.HostKeyCallback()
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		if e := callback(hostname, remote, key); e != nil {
+			return &HostKeyError{Host: hostname, KnownHostsPath: knownHostsPath, OpErr: e}
+		}
+		return nil
+	}, algorithms, nil
+}
+
+/*
+hostKeyAlgorithmsAuth restricts the host key algorithms offered to the server. go-git only does this
+for its own default known_hosts callback, not for one set on the auth method.
+*/
+type hostKeyAlgorithmsAuth struct {
+	ssh.AuthMethod
+	algorithms []string
+}
+
+func (a *hostKeyAlgorithmsAuth) ClientConfig() (*gossh.ClientConfig, error) {
+	cfg, err := a.AuthMethod.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
+	cfg.HostKeyAlgorithms = a.algorithms
+
+	return cfg, nil
+}
 
-	rerooted, err := mfs.Chroot(gc.CurrentSource.Path)
+// withHostKeyAlgorithms wraps auth to offer only algorithms, or returns it as is when there are none.
+func withHostKeyAlgorithms(auth ssh.AuthMethod, algorithms []string) ssh.AuthMethod {
+	if len(algorithms) == 0 {
+		return auth
+	}
+
+	return &hostKeyAlgorithmsAuth{AuthMethod: auth, algorithms: algorithms}
+}
+
+/*
+Resolve returns the commit the source's ref, or the remote HEAD when no ref is set, currently points
+at on the remote without fetching any content. A commit SHA the remote doesn't advertise is returned
+as given.
+*/
+func (gc *GitClient) Resolve(ctx context.Context) (string, error) {
+	gitAuth, err := gc.auth(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	advertised, err := gc.listRemote(ctx, gitAuth)
+	if err != nil {
+		gc.settleCredentials(ctx, gitAuth, err)
+		return "", err
+	}
+
+	ref := gc.CurrentSource.Ref
+	if ref == "" {
+		ref = plumbing.HEAD.String()
+	}
+
+	r := matchRef(advertised, ref)
+	if r != nil && r.Type() == plumbing.SymbolicReference {
+		r = matchRef(advertised, r.Target().String())
+	}
+	if r != nil {
+		return r.Hash().String(), nil
+	}
+
+	if isCommitHash(ref) {
+		return strings.ToLower(ref), nil
+	}
+
+	return "", &GitRefError{URL: gc.CurrentSource.URL, Ref: ref, OpErr: errGitRefNotFound}
+}
+
+// listRemote returns the refs advertised by the source's remote.
+func (gc *GitClient) listRemote(ctx context.Context, auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gc.CurrentSource.URL},
+	})
+
+	return remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+}
+
+// matchRef finds ref among the advertised refs as a branch, then a tag, then a full ref name.
+func matchRef(advertised []*plumbing.Reference, ref string) *plumbing.Reference {
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+		plumbing.ReferenceName(ref),
+	}
+	for _, name := range candidates {
+		for _, r := range advertised {
+			if r.Name() == name {
+				return r
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+cloneRef fetches Source.Ref into stg. The ref is matched against the branches and tags the remote
+advertises, in that order, and a match is shallow cloned as usual. Anything else that looks like a
+commit SHA is fetched with fetchCommit.
+*/
+func (gc *GitClient) cloneRef(
+	ctx context.Context,
+	stg *filesystem.Storage,
+	auth transport.AuthMethod,
+) (*git.Repository, error) {
+	ref := gc.CurrentSource.Ref
+
+	advertised, err := gc.listRemote(ctx, auth)
 	if err != nil {
 		return nil, err
 	}
 
-	return rerooted, nil
+	if r := matchRef(advertised, ref); r != nil {
+		return git.CloneContext(ctx, stg, nil, &git.CloneOptions{
+			URL:           gc.CurrentSource.URL,
+			Auth:          auth,
+			ReferenceName: r.Name(),
+			Depth:         1,
+			SingleBranch:  true,
+		})
+	}
 
-	// err = CopyFileSystem(rerooted, "/", cloneOpts.DestinationPath, cloneOpts.DestinationFs)
-	// if err != nil {
-	// 	return err
-	// }
+	if !isCommitHash(ref) {
+		return nil, &GitRefError{URL: gc.CurrentSource.URL, Ref: ref, OpErr: errGitRefNotFound}
+	}
 
-	// return nil
+	return gc.fetchCommit(ctx, stg, auth)
 }
 
-func (gc *GitClient) SetSource(s *types.Source) {
-	gc.CurrentSource = (*types.GitSource)(s)
+/*
+fetchCommit fetches the commit named by Source.Ref into stg and points HEAD at it. A full SHA is first
+fetched on its own at depth 1, which only works when the server allows unadvertised objects to be
+requested (uploadpack.allowReachableSHA1InWant and friends). When it doesn't, or the SHA is abbreviated,
+every branch and tag is fetched with full history and the commit is looked up locally.
+*/
+func (gc *GitClient) fetchCommit(
+	ctx context.Context,
+	stg *filesystem.Storage,
+	auth transport.AuthMethod,
+) (*git.Repository, error) {
+	ref := gc.CurrentSource.Ref
+
+	repo, err := git.Init(stg, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gc.CurrentSource.URL},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := false
+	if plumbing.IsHash(ref) {
+		err = repo.FetchContext(ctx, &git.FetchOptions{
+			Auth:     auth,
+			Depth:    1,
+			RefSpecs: []config.RefSpec{config.RefSpec(ref + ":" + pinnedRef)},
+		})
+		fetched = err == nil || errors.Is(err, git.NoErrAlreadyUpToDate)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+	}
+
+	if !fetched {
+		err = repo.FetchContext(ctx, &git.FetchOptions{
+			Auth: auth,
+			RefSpecs: []config.RefSpec{
+				"+refs/heads/*:refs/remotes/origin/*",
+				"+refs/tags/*:refs/tags/*",
+			},
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil, err
+		}
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, &GitRefError{URL: gc.CurrentSource.URL, Ref: ref, OpErr: errGitRefNotFound}
+	}
+
+	err = stg.SetReference(plumbing.NewHashReference(plumbing.HEAD, *hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// isCommitHash reports whether ref could be a full or abbreviated commit SHA.
+func isCommitHash(ref string) bool {
+	if len(ref) < minAbbrevHashLen || len(ref) > hex.EncodedLen(len(plumbing.ZeroHash)) {
+		return false
+	}
+	for _, c := range ref {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	gitCredentialFillAction    = "fill"
+	gitCredentialApproveAction = "approve"
+	gitCredentialRejectAction  = "reject"
+)
+
+/*
+gitCredentialFill asks git for the credentials of the http(s) remote at ep with `git credential fill`,
+so whatever credential helpers git is configured with are used, as they would be by git itself. username
+is passed on when set, for helpers that store more than one account per host. git is never let ask on the
+terminal, as fills run inside fetches bounded by SourceTimeout, so a remote no helper holds credentials
+for fails at once.
+*/
+func gitCredentialFill(ctx context.Context, ep *transport.Endpoint, username string) (*http.BasicAuth, error) {
+	stdout, err := gitCredential(ctx, gitCredentialFillAction, ep, &http.BasicAuth{Username: username})
+	if err != nil {
+		return nil, err
+	}
+
+	basicAuth := &http.BasicAuth{}
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "username":
+			basicAuth.Username = value
+		case "password":
+			basicAuth.Password = value
+		}
+	}
+	if basicAuth.Password == "" {
+		return nil, errNoCredentials
+	}
+
+	return basicAuth, nil
+}
+
+/*
+gitCredentialSettle tells git's credential helpers how credentials from gitCredentialFill fared with
+`git credential approve` once the remote accepted them, so helpers can store them, or `git credential
+reject` when it refused them, so helpers forget them. fetchErr is the outcome of using them, errors
+other than an authentication failure say nothing about the credentials and are ignored.
+*/
+func gitCredentialSettle(ctx context.Context, ep *transport.Endpoint, basicAuth *http.BasicAuth, fetchErr error) error {
+	action := gitCredentialApproveAction
+	if fetchErr != nil {
+		if !isAuthFailure(fetchErr) {
+			return nil
+		}
+		action = gitCredentialRejectAction
+	}
+
+	_, err := gitCredential(ctx, action, ep, basicAuth)
+	return err
+}
+
+// isAuthFailure reports whether err is the remote refusing the credentials it was given.
+func isAuthFailure(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+// gitCredential runs `git credential <action>` for the remote at ep and basicAuth, returning its output.
+func gitCredential(ctx context.Context, action string, ep *transport.Endpoint, basicAuth *http.BasicAuth) ([]byte, error) {
+	host := ep.Host
+	if ep.Port != 0 {
+		host += ":" + strconv.Itoa(ep.Port)
+	}
+
+	var input strings.Builder
+	fmt.Fprintf(&input, "protocol=%s\nhost=%s\npath=%s\n", ep.Protocol, host, strings.TrimPrefix(ep.Path, "/"))
+	if basicAuth.Username != "" {
+		fmt.Fprintf(&input, "username=%s\n", basicAuth.Username)
+	}
+	if basicAuth.Password != "" {
+		fmt.Fprintf(&input, "password=%s\n", basicAuth.Password)
+	}
+	input.WriteString("\n")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "credential", action)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -4,21 +4,37 @@ package storage_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/OneFineDev/tmpltr/internal/storage"
 	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 // setupFixtureGitRepo sets up an in-memory Git repository with test files.
@@ -225,3 +241,776 @@ func TestCloneSource(t *testing.T) {
 		})
 	}
 }
+
+// fixtureRefRepo describes the commits created by setupFixtureRefRepo.
+type fixtureRefRepo struct {
+	dir      string
+	released plumbing.Hash
+	head     plumbing.Hash
+	feature  plumbing.Hash
+}
+
+/*
+setupFixtureRefRepo creates an on-disk repository with a v1.0 tag on its first commit, a second commit
+on the default branch and a third on a feature branch, each with a different version.txt.
+*/
+func setupFixtureRefRepo(t *testing.T) fixtureRefRepo {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(version string) plumbing.Hash {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "version.txt"), []byte(version), 0644))
+		_, err = wt.Add("version.txt")
+		require.NoError(t, err)
+		hash, err := wt.Commit(version, &git.CommitOptions{
+			Author: &object.Signature{Name: "Test User", Email: "test@example.com"},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+
+	fixture := fixtureRefRepo{dir: dir}
+	fixture.released = commit("1.0")
+	_, err = repo.CreateTag("v1.0", fixture.released, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Test User", Email: "test@example.com"},
+		Message: "v1.0",
+	})
+	require.NoError(t, err)
+	fixture.head = commit("2.0")
+
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}))
+	fixture.feature = commit("3.0-feature")
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}))
+
+	return fixture
+}
+
+func TestGitClient_CloneRef(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository over the file transport")
+	}
+	fixture := setupFixtureRefRepo(t)
+
+	tests := []struct {
+		name            string
+		ref             string
+		expectedVersion string
+		expectRefErr    bool
+	}{
+		{name: "no ref clones the default branch", ref: "", expectedVersion: "2.0"},
+		{name: "branch", ref: "feature", expectedVersion: "3.0-feature"},
+		{name: "annotated tag", ref: "v1.0", expectedVersion: "1.0"},
+		{name: "full commit sha", ref: fixture.released.String(), expectedVersion: "1.0"},
+		{name: "abbreviated commit sha", ref: fixture.feature.String()[:10], expectedVersion: "3.0-feature"},
+		{name: "missing branch or tag", ref: "does-not-exist", expectRefErr: true},
+		{name: "missing commit", ref: "0123456789abcdef0123456789abcdef01234567", expectRefErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				Alias:      "fixture",
+				SourceType: types.GitSourceType,
+				URL:        fixture.dir,
+				Path:       "/",
+				Ref:        tt.ref,
+			})
+
+			// Act
+			bfs, err := gc.Clone(t.Context())
+
+			// Assert
+			if tt.expectRefErr {
+				var refErr *storage.GitRefError
+				require.ErrorAs(t, err, &refErr)
+				assert.Equal(t, tt.ref, refErr.Ref)
+				return
+			}
+			require.NoError(t, err)
+			content, err := util.ReadFile(bfs, "version.txt")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVersion, string(content))
+		})
+	}
+}
+
+// gitHTTPBackend serves the repositories under root over smart HTTP using git http-backend. Paths under
+// /private/ require basic auth as ci:s3cret.
+func gitHTTPBackend(t *testing.T, root string) *httptest.Server {
+	out, err := exec.Command("git", "--exec-path").Output()
+	require.NoError(t, err)
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/private/") {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "s3cret" {
+				w.Header().Set("Www-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestGitClient_CloneTransports(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository")
+	}
+	fixture := setupFixtureRefRepo(t)
+
+	root := t.TempDir()
+	for _, name := range []string{"public/templates.git", "private/templates.git"} {
+		_, err := git.PlainClone(filepath.Join(root, name), true, &git.CloneOptions{URL: fixture.dir})
+		require.NoError(t, err)
+	}
+	server := gitHTTPBackend(t, root)
+
+	invalidKey := filepath.Join(t.TempDir(), "id_invalid")
+	require.NoError(t, os.WriteFile(invalidKey, []byte("not a key"), 0600))
+
+	// Give git credential fill a credential store holding the fixture server's credentials and nothing else.
+	gitConfigDir := t.TempDir()
+	credentials := filepath.Join(gitConfigDir, "credentials")
+	serverURL := strings.Replace(server.URL, "http://", "http://ci:s3cret@", 1)
+	require.NoError(t, os.WriteFile(credentials, []byte(serverURL+"\n"), 0600))
+	gitConfig := filepath.Join(gitConfigDir, "gitconfig")
+	require.NoError(t, os.WriteFile(gitConfig,
+		[]byte("[credential]\n\thelper = store --file="+credentials+"\n"), 0600))
+	t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	unknownHostURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name        string
+		url         string
+		sourceAuth  *types.SourceAuth
+		expectedErr error
+	}{
+		{
+			name: "local path without credentials",
+			url:  fixture.dir,
+		},
+		{
+			name: "file url to a bare repository without credentials",
+			url:  "file://" + filepath.Join(root, "public/templates.git"),
+		},
+		{
+			name: "public http repository without credentials",
+			url:  server.URL + "/public/templates.git",
+		},
+		{
+			name:       "private http repository with basic auth",
+			url:        server.URL + "/private/templates.git",
+			sourceAuth: &types.SourceAuth{AuthAlias: "ci", UserName: "ci", Pat: "s3cret"},
+		},
+		{
+			name: "private http repository with git credential helper",
+			url:  server.URL + "/private/templates.git",
+			sourceAuth: &types.SourceAuth{
+				AuthAlias:        "ci",
+				CredentialHelper: types.GitCredentialHelper,
+			},
+		},
+		{
+			name: "git credential helper without stored credentials",
+			url:  unknownHostURL + "/private/templates.git",
+			sourceAuth: &types.SourceAuth{
+				AuthAlias:        "ci",
+				CredentialHelper: types.GitCredentialHelper,
+			},
+			expectedErr: &storage.CredentialHelperError{},
+		},
+		{
+			name:        "unsupported credential helper",
+			url:         server.URL + "/private/templates.git",
+			sourceAuth:  &types.SourceAuth{AuthAlias: "ci", CredentialHelper: "keychain"},
+			expectedErr: &storage.CredentialHelperError{},
+		},
+		{
+			name:        "private http repository without credentials",
+			url:         server.URL + "/private/templates.git",
+			expectedErr: transport.ErrAuthenticationRequired,
+		},
+		{
+			name:        "ssh key on an http url",
+			url:         server.URL + "/public/templates.git",
+			sourceAuth:  &types.SourceAuth{AuthAlias: "ado", SSHKey: invalidKey},
+			expectedErr: &storage.TransportAuthMismatchError{},
+		},
+		{
+			name:        "pat on an scp-like ssh url",
+			url:         "git@ssh.dev.azure.com:v3/org/project/templates",
+			sourceAuth:  &types.SourceAuth{AuthAlias: "ado", Pat: "s3cret"},
+			expectedErr: &storage.TransportAuthMismatchError{},
+		},
+		{
+			name:        "unreadable ssh key",
+			url:         "ssh://git@github.com/org/templates.git",
+			sourceAuth:  &types.SourceAuth{AuthAlias: "github", SSHKey: invalidKey},
+			expectedErr: &storage.SSHKeyError{},
+		},
+		{
+			name:        "unsupported scheme",
+			url:         "ftp://example.com/templates.git",
+			expectedErr: &storage.GitURLError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				Alias:      "fixture",
+				SourceType: types.GitSourceType,
+				URL:        tt.url,
+				Path:       "/",
+				SourceAuth: tt.sourceAuth,
+			})
+
+			// Act
+			bfs, err := gc.Clone(t.Context())
+
+			// Assert
+			switch e := tt.expectedErr.(type) {
+			case nil:
+			case *storage.TransportAuthMismatchError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.SSHKeyError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.GitURLError:
+				require.ErrorAs(t, err, &e)
+				return
+			case *storage.CredentialHelperError:
+				require.ErrorAs(t, err, &e)
+				return
+			default:
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			content, err := util.ReadFile(bfs, "version.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "2.0", string(content))
+		})
+	}
+}
+
+func TestGitClient_CredentialHelperSettle(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository")
+	}
+	fixture := setupFixtureRefRepo(t)
+
+	root := t.TempDir()
+	_, err := git.PlainClone(filepath.Join(root, "private/templates.git"), true, &git.CloneOptions{URL: fixture.dir})
+	require.NoError(t, err)
+	server := gitHTTPBackend(t, root)
+
+	tests := []struct {
+		name           string
+		password       string
+		expectedAction string
+		expectErr      bool
+	}{
+		{
+			name:           "accepted credentials are approved",
+			password:       "s3cret",
+			expectedAction: "store",
+		},
+		{
+			name:           "refused credentials are rejected",
+			password:       "wrong",
+			expectedAction: "erase",
+			expectErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			// A helper that hands out tt.password and logs what git tells it.
+			gitConfigDir := t.TempDir()
+			actions := filepath.Join(gitConfigDir, "actions")
+			helper := fmt.Sprintf(`!f() { echo "$1" >> %s; test "$1" = get && printf 'username=ci\npassword=%s\n'; }; f`,
+				actions, tt.password)
+			gitConfig := filepath.Join(gitConfigDir, "gitconfig")
+			require.NoError(t, os.WriteFile(gitConfig, []byte(fmt.Sprintf("[credential]\n\thelper = %q\n", helper)), 0600))
+			t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+			t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				Alias:      "fixture",
+				SourceType: types.GitSourceType,
+				URL:        server.URL + "/private/templates.git",
+				Path:       "/",
+				SourceAuth: &types.SourceAuth{AuthAlias: "ci", CredentialHelper: types.GitCredentialHelper},
+			})
+
+			// Act
+			_, err := gc.Clone(t.Context())
+
+			// Assert
+			if tt.expectErr {
+				require.ErrorIs(t, err, transport.ErrAuthenticationRequired)
+			} else {
+				require.NoError(t, err)
+			}
+			logged, err := os.ReadFile(actions)
+			require.NoError(t, err)
+			assert.Equal(t, "get\n"+tt.expectedAction+"\n", string(logged))
+		})
+	}
+}
+
+func TestGitClient_Resolve(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository over the file transport")
+	}
+	fixture := setupFixtureRefRepo(t)
+
+	tests := []struct {
+		name         string
+		ref          string
+		expected     string
+		expectRefErr bool
+	}{
+		{name: "no ref resolves the remote head", ref: "", expected: fixture.head.String()},
+		{name: "branch", ref: "feature", expected: fixture.feature.String()},
+		{name: "unadvertised commit sha is returned as given", ref: "ABCDEF12", expected: "abcdef12"},
+		{name: "missing branch or tag", ref: "does-not-exist", expectRefErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				Alias:      "fixture",
+				SourceType: types.GitSourceType,
+				URL:        fixture.dir,
+				Ref:        tt.ref,
+			})
+
+			// Act
+			resolved, err := gc.Resolve(t.Context())
+
+			// Assert
+			if tt.expectRefErr {
+				var refErr *storage.GitRefError
+				require.ErrorAs(t, err, &refErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, resolved)
+		})
+	}
+}
+
+// serveSSHHandshake accepts ssh connections with hostKey and closes them once the handshake is done.
+func serveSSHHandshake(t *testing.T, hostKey gossh.Signer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	serverConfig := &gossh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, e := listener.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if sc, _, _, e := gossh.NewServerConn(conn, serverConfig); e == nil {
+					sc.Close()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestGitClient_CloneVerifiesHostKey(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKey(clientKey, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := gossh.NewSignerFromKey(hostPrivateKey)
+	require.NoError(t, err)
+	addr := serveSSHHandshake(t, hostKey)
+
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, port))}, hostKey.PublicKey())
+
+	tests := []struct {
+		name             string
+		knownHosts       string
+		expectHostKeyErr bool
+	}{
+		{
+			name:             "rejects a host missing from known_hosts",
+			expectHostKeyErr: true,
+		},
+		{
+			name:       "accepts a host in known_hosts",
+			knownHosts: knownHostsLine + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+			require.NoError(t, os.WriteFile(knownHosts, []byte(tt.knownHosts), 0600))
+
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				SourceType:     types.GitSourceType,
+				URL:            "ssh://git@" + addr + "/onefinedev/templates.git",
+				KnownHostsPath: knownHosts,
+				SourceAuth:     &types.SourceAuth{SSHKey: keyPath},
+			})
+
+			// Act
+			_, err := gc.Clone(t.Context())
+
+			// Assert
+			// The server closes the connection after the handshake, so the clone always fails.
+			require.Error(t, err)
+			var hostKeyErr *storage.HostKeyError
+			if !tt.expectHostKeyErr {
+				assert.NotErrorAs(t, err, &hostKeyErr)
+				return
+			}
+			require.ErrorAs(t, err, &hostKeyErr)
+			assert.Equal(t, knownHosts, hostKeyErr.KnownHostsPath)
+		})
+	}
+}
+
+// commitFixtureTree creates an on-disk repository with one commit holding files, symlinks and
+// executables, keyed by path.
+func commitFixtureTree(tb testing.TB, files map[string]string, executables []string, symlinks map[string]string) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	for p, content := range files {
+		require.NoError(tb, os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0755))
+		require.NoError(tb, os.WriteFile(filepath.Join(dir, p), []byte(content), 0644))
+	}
+	for _, p := range executables {
+		require.NoError(tb, os.Chmod(filepath.Join(dir, p), 0755))
+	}
+	for p, target := range symlinks {
+		require.NoError(tb, os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0755))
+		require.NoError(tb, os.Symlink(target, filepath.Join(dir, p)))
+	}
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(tb, err)
+	wt, err := repo.Worktree()
+	require.NoError(tb, err)
+	require.NoError(tb, wt.AddWithOptions(&git.AddOptions{All: true}))
+	_, err = wt.Commit("fixture", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test User", Email: "test@example.com"},
+	})
+	require.NoError(tb, err)
+
+	return dir
+}
+
+func TestGitClient_ClonePath(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository over the file transport")
+	}
+	dir := commitFixtureTree(t,
+		map[string]string{
+			"terraform/main.tf":         "terraform {}",
+			"terraform/scripts/init.sh": "#!/bin/sh",
+			"go-web/main.go.template":   "package {{.packageName}}",
+		},
+		[]string{"terraform/scripts/init.sh"},
+		map[string]string{
+			"terraform/main.tf.link": "main.tf",
+			"go-web/terraform":       "../terraform",
+			"docs/here":              ".",
+			"docs/up":                "here/..",
+		},
+	)
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedFiles    map[string]string
+		unexpectedFiles  []string
+		expectedModes    map[string]os.FileMode
+		expectedSymlinks map[string]string
+		expectPathErr    bool
+		expectUnsafeLink bool
+	}{
+		{
+			name: "root",
+			path: "/",
+			expectedFiles: map[string]string{
+				"terraform/main.tf":       "terraform {}",
+				"go-web/main.go.template": "package {{.packageName}}",
+			},
+		},
+		{
+			name: "only files under the path",
+			path: "/terraform",
+			expectedFiles: map[string]string{
+				"main.tf":         "terraform {}",
+				"scripts/init.sh": "#!/bin/sh",
+			},
+			unexpectedFiles:  []string{"go-web", "terraform"},
+			expectedModes:    map[string]os.FileMode{"main.tf": 0644, "scripts/init.sh": 0755},
+			expectedSymlinks: map[string]string{"main.tf.link": "main.tf"},
+		},
+		{
+			name:          "nested path without leading slash",
+			path:          "terraform/scripts",
+			expectedFiles: map[string]string{"init.sh": "#!/bin/sh"},
+		},
+		{
+			name:          "missing path",
+			path:          "/ansible",
+			expectPathErr: true,
+		},
+		{
+			name:          "path to a file",
+			path:          "/terraform/main.tf",
+			expectPathErr: true,
+		},
+		{
+			name:             "symlinks within the path",
+			path:             "/",
+			expectedSymlinks: map[string]string{"go-web/terraform": "../terraform", "docs/up": "here/.."},
+		},
+		{
+			name:             "symlink leaving the path",
+			path:             "/go-web",
+			expectPathErr:    true,
+			expectUnsafeLink: true,
+		},
+		{
+			name:             "symlink leaving the path through another symlink",
+			path:             "/docs",
+			expectPathErr:    true,
+			expectUnsafeLink: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{
+				Alias:      "fixture",
+				SourceType: types.GitSourceType,
+				URL:        dir,
+				Path:       tt.path,
+			})
+
+			// Act
+			bfs, err := gc.Clone(t.Context())
+
+			// Assert
+			if tt.expectPathErr {
+				var pathErr *storage.SourcePathError
+				require.ErrorAs(t, err, &pathErr)
+				assert.Equal(t, tt.path, pathErr.SourcePath)
+				if tt.expectUnsafeLink {
+					var linkErr *storage.UnsafeSymlinkError
+					require.ErrorAs(t, err, &linkErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+			for _, p := range tt.unexpectedFiles {
+				_, e := bfs.Stat(p)
+				assert.ErrorIs(t, e, os.ErrNotExist, p)
+			}
+			for p, mode := range tt.expectedModes {
+				info, e := bfs.Stat(p)
+				require.NoError(t, e)
+				assert.Equal(t, mode, info.Mode().Perm(), p)
+			}
+			for p, target := range tt.expectedSymlinks {
+				link, e := bfs.Readlink(p)
+				require.NoError(t, e)
+				assert.Equal(t, target, link)
+			}
+		})
+	}
+}
+
+/*
+BenchmarkGitClient_ClonePath clones a small path out of a large repository and the whole repository, so
+the cost of the two can be compared. The repository holds 40MB across 5,000 files alongside a 10 file
+terraform directory.
+*/
+func BenchmarkGitClient_ClonePath(b *testing.B) {
+	if _, err := exec.LookPath("git"); err != nil {
+		b.Skip("git is required to serve the fixture repository over the file transport")
+	}
+
+	const (
+		dirs        = 50
+		filesPerDir = 100
+		fileSize    = 8 << 10
+	)
+	files := make(map[string]string, dirs*filesPerDir)
+	for d := range dirs {
+		for f := range filesPerDir {
+			// Vary the content so git can't deduplicate or delta compress it away.
+			content := strings.Repeat(fmt.Sprintf("%d-%d ", d, f), fileSize/8)
+			files[fmt.Sprintf("templates-%02d/file-%03d.txt.template", d, f)] = content
+		}
+	}
+	for f := range 10 {
+		files[fmt.Sprintf("terraform/module-%d.tf", f)] = "terraform {}"
+	}
+	dir := commitFixtureTree(b, files, nil, nil)
+
+	for name, path := range map[string]string{"terraform": "/terraform", "whole repository": "/"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				gc := storage.NewGitClient()
+				gc.SetSource(&types.Source{SourceType: types.GitSourceType, URL: dir, Path: path})
+				if _, err := gc.Clone(b.Context()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGitClient_Fetch(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository over the file transport")
+	}
+	dir := commitFixtureTree(t,
+		map[string]string{
+			"terraform/main.tf":       "terraform {}",
+			"go-web/main.go.template": "package {{.packageName}}",
+		}, nil, nil,
+	)
+	gc := storage.NewGitClient()
+	gc.SetSource(&types.Source{SourceType: types.GitSourceType, URL: dir})
+
+	// Act
+	snapshot, err := gc.Fetch(t.Context())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, snapshot.Close()) })
+	terraform, terraformErr := snapshot.Path("/terraform")
+	goWeb, goWebErr := snapshot.Path("/go-web")
+	_, missingErr := snapshot.Path("/ansible")
+
+	// Assert
+	require.NoError(t, terraformErr)
+	content, err := util.ReadFile(terraform, "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, "terraform {}", string(content))
+
+	require.NoError(t, goWebErr)
+	content, err = util.ReadFile(goWeb, "main.go.template")
+	require.NoError(t, err)
+	assert.Equal(t, "package {{.packageName}}", string(content))
+
+	var pathErr *storage.SourcePathError
+	require.ErrorAs(t, missingErr, &pathErr)
+}
+
+func TestGitClient_FetchRemovesTempDir(t *testing.T) {
+	// Arrange
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the fixture repository over the file transport")
+	}
+	fixture := setupFixtureRefRepo(t)
+
+	tests := []struct {
+		name      string
+		ref       string
+		expectErr bool
+	}{
+		{
+			name: "closed snapshot",
+			ref:  "v1.0",
+		},
+		{
+			name:      "unknown ref",
+			ref:       "no-such-branch",
+			expectErr: true,
+		},
+		{
+			name:      "unknown commit",
+			ref:       strings.Repeat("f", 40),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+			gc := storage.NewGitClient()
+			gc.SetSource(&types.Source{SourceType: types.GitSourceType, URL: fixture.dir, Ref: tt.ref})
+
+			// Act
+			snapshot, err := gc.Fetch(t.Context())
+			if err == nil {
+				err = snapshot.Close()
+			}
+
+			// Assert
+			if tt.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			left, err := os.ReadDir(tmp)
+			require.NoError(t, err)
+			assert.Empty(t, left)
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultJSONIndent = "  "
+	defaultYAMLIndent = 2
+
+	// templateSuffix marks template files, which are merged before it's removed when they're rendered.
+	templateSuffix = ".template"
+)
+
+// mergeFormat returns the merge format for the file at name, going by its name and extension without any .template suffix.
+func mergeFormat(name string) types.MergeFormat {
+	base := strings.TrimSuffix(path.Base(name), templateSuffix)
+	switch base {
+	case ".gitignore", ".dockerignore", ".gitattributes", ".editorconfig":
+		return types.LinesMergeFormat
+	}
+
+	switch path.Ext(base) {
+	case ".json":
+		return types.JSONMergeFormat
+	case ".yaml", ".yml":
+		return types.YAMLMergeFormat
+	default:
+		return types.ConcatMergeFormat
+	}
+}
+
+// mergeHandler returns the MergeFunc for format, or nil for an unknown format.
+func mergeHandler(format types.MergeFormat) MergeFunc {
+	switch format {
+	case types.JSONMergeFormat:
+		return mergeJSON
+	case types.YAMLMergeFormat:
+		return mergeYAML
+	case types.LinesMergeFormat:
+		return mergeLines
+	case types.ConcatMergeFormat:
+		return concatMerge
+	default:
+		return nil
+	}
+}
+
+// concatMerge appends incoming to existing, starting it on a new line.
+func concatMerge(_ string, existing, incoming []byte) ([]byte, error) {
+	merged := bytes.Clone(existing)
+	if len(merged) > 0 && !bytes.HasSuffix(merged, []byte("\n")) {
+		merged = append(merged, '\n')
+	}
+	return append(merged, incoming...), nil
+}
+
+/*
+mergeLines keeps every line of existing and adds the lines of incoming it doesn't already hold, in
+the order incoming has them. A blank line in incoming is kept, once, ahead of the next line added.
+*/
+func mergeLines(_ string, existing, incoming []byte) ([]byte, error) {
+	lines := splitLines(existing)
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		seen[strings.TrimRight(line, " \t")] = true
+	}
+
+	added := 0
+	blank := false
+	for _, line := range splitLines(incoming) {
+		key := strings.TrimRight(line, " \t")
+		switch {
+		case key == "":
+			blank = true
+		case !seen[key]:
+			if blank && len(lines) > 0 && lines[len(lines)-1] != "" {
+				lines = append(lines, "")
+			}
+			seen[key] = true
+			lines = append(lines, line)
+			added++
+			blank = false
+		}
+	}
+
+	if added == 0 {
+		return existing, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// splitLines splits data into lines without their line endings.
+func splitLines(data []byte) []string {
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+/*
+mergeJSON deep merges incoming into existing. Objects are merged key by key, keeping the order keys
+were first seen in, arrays gain the items of incoming they don't already hold, and any other value in
+incoming replaces the one in existing. The result is indented like existing. Both may be JSONC, as
+VS Code settings often are, but the comments and trailing commas aren't kept in the result.
+*/
+func mergeJSON(_ string, existing, incoming []byte) ([]byte, error) {
+	e, err := decodeJSON(stripJSONC(existing))
+	if err != nil {
+		return nil, errNotJSON(err)
+	}
+	i, err := decodeJSON(stripJSONC(incoming))
+	if err != nil {
+		return nil, errNotJSON(err)
+	}
+
+	var compact bytes.Buffer
+	if err = encodeJSON(&compact, mergeJSONValues(e, i)); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err = json.Indent(&out, compact.Bytes(), "", detectIndent(existing, defaultJSONIndent)); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+
+	return out.Bytes(), nil
+}
+
+func errNotJSON(err error) error {
+	return fmt.Errorf("not JSON or JSONC, a conflicts rule with merge: concat keeps both copies instead: %w", err)
+}
+
+// stripJSONC removes the comments and trailing commas of JSONC from data, leaving JSON.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			// The line ending is kept.
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += 2 + end + 1
+		case c == '}' || c == ']':
+			trimmed := bytes.TrimRight(out, " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				out = trimmed[:len(trimmed)-1]
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// jsonObject is a decoded JSON object that keeps its keys in the order they were read.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func mergeJSONValues(existing, incoming any) any {
+	if e, ok := existing.(*jsonObject); ok {
+		if i, ok := incoming.(*jsonObject); ok {
+			for _, key := range i.keys {
+				if value, ok := e.values[key]; ok {
+					e.values[key] = mergeJSONValues(value, i.values[key])
+					continue
+				}
+				e.keys = append(e.keys, key)
+				e.values[key] = i.values[key]
+			}
+			return e
+		}
+	}
+
+	if e, ok := existing.([]any); ok {
+		if i, ok := incoming.([]any); ok {
+			return unionValues(e, i)
+		}
+	}
+
+	return incoming
+}
+
+// unionValues returns existing followed by the items of incoming existing doesn't hold.
+func unionValues(existing, incoming []any) []any {
+	merged := existing
+	for _, item := range incoming {
+		found := false
+		for _, e := range merged {
+			if reflect.DeepEqual(e, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected content after top-level JSON value")
+	}
+
+	return v, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := &jsonObject{values: make(map[string]any)}
+		for dec.More() {
+			keyTok, e := dec.Token()
+			if e != nil {
+				return nil, e
+			}
+			key, _ := keyTok.(string)
+			value, e := decodeJSONValue(dec)
+			if e != nil {
+				return nil, e
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = value
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, e := decodeJSONValue(dec)
+			if e != nil {
+				return nil, e
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	default:
+		return tok, nil
+	}
+}
+
+func encodeJSON(buf *bytes.Buffer, v any) error {
+	switch t := v.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+		for n, key := range t.keys {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, t.values[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for n, item := range t {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+		// Encode ends every value with a newline.
+		buf.Truncate(buf.Len() - 1)
+	}
+	return nil
+}
+
+// detectIndent returns the indentation of the first indented line of data, or fallback when none is.
+func detectIndent(data []byte, fallback string) string {
+	for _, line := range splitLines(data) {
+		content := strings.TrimLeft(line, " \t")
+		if content != "" && len(content) < len(line) {
+			return line[:len(line)-len(content)]
+		}
+	}
+	return fallback
+}
+
+/*
+mergeYAML deep merges incoming into existing, both single YAML documents. Mappings are merged key by
+key, keeping the order keys were first seen in, sequences gain the items of incoming they don't
+already hold, and any other node in incoming replaces the one in existing.
+*/
+func mergeYAML(_ string, existing, incoming []byte) ([]byte, error) {
+	e, err := decodeYAMLDocument(existing)
+	if err != nil {
+		return nil, err
+	}
+	i, err := decodeYAMLDocument(incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case i == nil:
+		return existing, nil
+	case e == nil:
+		return incoming, nil
+	}
+
+	indent := defaultYAMLIndent
+	if detected := detectIndent(existing, ""); detected != "" && strings.Trim(detected, " ") == "" {
+		indent = len(detected)
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(indent)
+	if err = enc.Encode(mergeYAMLNodes(e, i)); err != nil {
+		return nil, err
+	}
+	if err = enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// decodeYAMLDocument decodes data into a document node, nil when data is empty.
+func decodeYAMLDocument(data []byte) (*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil //nolint:nilnil // an empty document has nothing to merge
+		}
+		return nil, err
+	}
+
+	var next yaml.Node
+	if err := dec.Decode(&next); !errors.Is(err, io.EOF) {
+		return nil, errors.New("files with more than one YAML document can't be merged")
+	}
+
+	return &doc, nil
+}
+
+func mergeYAMLNodes(existing, incoming *yaml.Node) *yaml.Node {
+	if existing.Kind != incoming.Kind {
+		return incoming
+	}
+
+	switch existing.Kind {
+	case yaml.DocumentNode:
+		existing.Content[0] = mergeYAMLNodes(existing.Content[0], incoming.Content[0])
+	case yaml.MappingNode:
+		for n := 0; n+1 < len(incoming.Content); n += 2 {
+			key, value := incoming.Content[n], incoming.Content[n+1]
+			if at := yamlMappingIndex(existing, key.Value); at >= 0 {
+				existing.Content[at+1] = mergeYAMLNodes(existing.Content[at+1], value)
+				continue
+			}
+			existing.Content = append(existing.Content, key, value)
+		}
+	case yaml.SequenceNode:
+		for _, item := range incoming.Content {
+			if !yamlSequenceHolds(existing, item) {
+				existing.Content = append(existing.Content, item)
+			}
+		}
+	default:
+		return incoming
+	}
+
+	return existing
+}
+
+// yamlMappingIndex returns the index of key's node in mapping, or -1 when mapping doesn't hold key.
+func yamlMappingIndex(mapping *yaml.Node, key string) int {
+	for n := 0; n+1 < len(mapping.Content); n += 2 {
+		if mapping.Content[n].Value == key {
+			return n
+		}
+	}
+	return -1
+}
+
+// yamlSequenceHolds reports whether sequence holds an item with the same value as item.
+func yamlSequenceHolds(sequence, item *yaml.Node) bool {
+	var want any
+	if err := item.Decode(&want); err != nil {
+		return false
+	}
+
+	for _, node := range sequence.Content {
+		var have any
+		if err := node.Decode(&have); err == nil && reflect.DeepEqual(have, want) {
+			return true
+		}
+	}
+	return false
+}
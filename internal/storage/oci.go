@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+const (
+	ociURLScheme  = "oci://"
+	ociDefaultTag = "latest"
+
+	ociMediaTypeManifest    = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeIndex       = "application/vnd.oci.image.index.v1+json"
+	dockerMediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerMediaTypeList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociAnnotationTitle      = "org.opencontainers.image.title"
+	orasAnnotationUnpack    = "io.deis.oras.content.unpack"
+)
+
+// ociReference is a parsed oci://registry/repository[:tag|@digest] reference.
+type ociReference struct {
+	Registry   string
+	Repository string
+	// Reference is the tag or digest to resolve.
+	Reference string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociRegistryErrors struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type ociTokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+type OCIClient struct {
+	CurrentSource *types.OCISource
+	HTTPClient    *http.Client
+
+	// bearer caches the token issued by the registry's auth service for the current Clone.
+	bearer string
+}
+
+// NewOCIClient creates a new OCIClient.
+func NewOCIClient() *OCIClient {
+	return &OCIClient{}
+}
+
+/*
+Clone pulls the artifact referenced by the source URL, oci://registry/repository[:tag|@digest], through the
+registry HTTP API and unpacks its layers, in order, into an in-memory filesystem. Tar layers are unpacked,
+and other layers are written as files named by their org.opencontainers.image.title annotation, as pushed
+by tools such as oras.
+
+UserName and Token (or Pat) on the source's auth are used as registry credentials, for both basic auth
+and the bearer token flow. Registries on localhost are reached over plain HTTP.
+*/
+func (oc *OCIClient) Clone(ctx context.Context) (billy.Filesystem, error) {
+	oc.bearer = ""
+
+	ref, err := parseOCIReference(oc.CurrentSource.URL)
+	if err != nil {
+		return nil, &OCIError{Reference: oc.CurrentSource.URL, OpErr: err}
+	}
+
+	manifest, err := oc.fetchManifest(ctx, ref)
+	if err != nil {
+		return nil, &OCIError{Reference: oc.CurrentSource.URL, OpErr: err}
+	}
+
+	mfs := memfs.New()
+
+	for _, layer := range manifest.Layers {
+		if err = oc.fetchLayer(ctx, ref, layer, mfs); err != nil {
+			return nil, &OCIError{Reference: oc.CurrentSource.URL, OpErr: fmt.Errorf("layer %s: %w", layer.Digest, err)}
+		}
+	}
+
+	return ChrootSourcePath(mfs, oc.CurrentSource.Path)
+}
+
+// Pinned returns the digest the source URL references, when it references one rather than a tag.
+func (oc *OCIClient) Pinned() (string, bool) {
+	ref, err := parseOCIReference(oc.CurrentSource.URL)
+	if err != nil || !strings.HasPrefix(ref.Reference, "sha256:") {
+		return "", false
+	}
+	return ref.Reference, true
+}
+
+// SetSource sets the current source.
+func (oc *OCIClient) SetSource(s *types.Source) {
+	oc.CurrentSource = (*types.OCISource)(s)
+}
+
+func (oc *OCIClient) httpClient() *http.Client {
+	if oc.HTTPClient != nil {
+		return oc.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// parseOCIReference parses oci://registry/repository[:tag|@digest], defaulting the tag to latest.
+func parseOCIReference(raw string) (ociReference, error) {
+	if !strings.HasPrefix(raw, ociURLScheme) {
+		return ociReference{}, errUnsupportedOCIURL
+	}
+
+	registry, repository, ok := strings.Cut(strings.TrimPrefix(raw, ociURLScheme), "/")
+	if !ok || registry == "" || repository == "" {
+		return ociReference{}, errUnsupportedOCIURL
+	}
+
+	ref := ociReference{Registry: registry, Repository: repository, Reference: ociDefaultTag}
+
+	if repo, digest, found := strings.Cut(repository, "@"); found {
+		ref.Repository, ref.Reference = repo, digest
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		ref.Repository, ref.Reference = repository[:i], repository[i+1:]
+	}
+
+	if ref.Repository == "" || ref.Reference == "" {
+		return ociReference{}, errUnsupportedOCIURL
+	}
+
+	return ref, nil
+}
+
+// baseURL returns the registry's API root, using plain HTTP for registries on the loopback interface.
+func (r ociReference) baseURL() string {
+	scheme := blobURLSchemeHTTPS
+
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(r.Registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = blobURLSchemeHTTP
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, r.Registry, r.Repository)
+}
+
+func (oc *OCIClient) fetchManifest(ctx context.Context, ref ociReference) (*ociManifest, error) {
+	accept := strings.Join([]string{
+		ociMediaTypeManifest, dockerMediaTypeManifest, ociMediaTypeIndex, dockerMediaTypeList,
+	}, ", ")
+
+	body, err := oc.get(ctx, ref, ref.baseURL()+"/manifests/"+ref.Reference, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(ref.Reference, "sha256:") {
+		if err = verifyDigest(ref.Reference, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest ociManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	if manifest.MediaType == ociMediaTypeIndex || manifest.MediaType == dockerMediaTypeList {
+		return nil, errOCIIndexUnsupported
+	}
+
+	return &manifest, nil
+}
+
+// fetchLayer downloads a layer blob, verifies its digest and writes its content into fs.
+func (oc *OCIClient) fetchLayer(ctx context.Context, ref ociReference, layer ociDescriptor, fs billy.Filesystem) error {
+	body, err := oc.get(ctx, ref, ref.baseURL()+"/blobs/"+layer.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Layers are buffered so the digest is verified before anything is written.
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err = verifyDigest(layer.Digest, data); err != nil {
+		return err
+	}
+
+	title := layer.Annotations[ociAnnotationTitle]
+
+	switch {
+	case strings.HasSuffix(layer.MediaType, "tar+gzip") || strings.HasSuffix(layer.MediaType, "tar.gzip") ||
+		layer.Annotations[orasAnnotationUnpack] == "true":
+		return unpackTarGz(bytes.NewReader(data), fs)
+	case strings.HasSuffix(layer.MediaType, ".tar"):
+		return unpackTar(bytes.NewReader(data), fs)
+	case title != "":
+		return writeFile(fs, archiveEntryPath(title), 0644, bytes.NewReader(data)) //nolint:mnd
+	default:
+		return fmt.Errorf("%w: %s", errOCILayerUnsupported, layer.MediaType)
+	}
+}
+
+/*
+get performs an authenticated GET against the registry. On a 401 it answers the registry's challenge,
+either with basic auth or by exchanging the credentials for a bearer token, and retries once.
+*/
+func (oc *OCIClient) get(ctx context.Context, ref ociReference, u string, accept string) (io.ReadCloser, error) {
+	resp, err := oc.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("Www-Authenticate")
+		resp.Body.Close()
+
+		if err = oc.answerChallenge(ctx, ref, challenge); err != nil {
+			return nil, err
+		}
+
+		resp, err = oc.do(ctx, u, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp, path.Base(u), decodeRegistryErrorBody)
+	}
+
+	return resp.Body, nil
+}
+
+func (oc *OCIClient) do(ctx context.Context, u string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	user, secret := oc.credentials()
+	switch {
+	case oc.bearer != "":
+		req.Header.Set("Authorization", "Bearer "+oc.bearer)
+	case secret != "":
+		req.SetBasicAuth(user, secret)
+	}
+
+	return oc.httpClient().Do(req)
+}
+
+// answerChallenge fetches a bearer token for a Bearer challenge. Basic challenges need no extra round trip,
+// since credentials are already sent as basic auth when no bearer token is held.
+func (oc *OCIClient) answerChallenge(ctx context.Context, ref ociReference, challenge string) error {
+	scheme, params := parseAuthChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return errOCIUnauthorized
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if user, secret := oc.credentials(); secret != "" {
+		req.SetBasicAuth(user, secret)
+	}
+
+	resp, err := oc.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, path.Base(tokenURL.Path), decodeRegistryErrorBody)
+	}
+
+	var token ociTokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+
+	oc.bearer = token.Token
+	if oc.bearer == "" {
+		oc.bearer = token.AccessToken
+	}
+	if oc.bearer == "" {
+		return errOCIUnauthorized
+	}
+
+	return nil
+}
+
+// credentials returns the registry username and secret, preferring Token over Pat.
+func (oc *OCIClient) credentials() (string, string) {
+	auth := oc.CurrentSource.SourceAuth
+	if auth == nil {
+		return "", ""
+	}
+	if auth.Token != "" {
+		return auth.UserName, auth.Token
+	}
+	return auth.UserName, auth.Pat
+}
+
+// parseAuthChallenge splits a WWW-Authenticate header into its scheme and parameters.
+func parseAuthChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			// Quoted values may contain commas, e.g. scope="repository:x:pull,push".
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+
+		rest = strings.TrimLeft(rest, ", ")
+	}
+
+	return scheme, params
+}
+
+// verifyDigest checks data against a sha256:<hex> digest.
+func verifyDigest(digest string, data []byte) error {
+	algorithm, expected, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return fmt.Errorf("%w: %s", errOCIDigestUnsupported, algorithm)
+	}
+
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expected {
+		return &ChecksumMismatchError{URL: digest, Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
+
+// decodeRegistryErrorBody decodes the code and message of the first error in a registry's error body.
+func decodeRegistryErrorBody(r io.Reader) (string, string) {
+	var regErrs ociRegistryErrors
+	if json.NewDecoder(r).Decode(&regErrs) != nil || len(regErrs.Errors) == 0 {
+		return "", ""
+	}
+	return regErrs.Errors[0].Code, regErrs.Errors[0].Message
+}
@@ -0,0 +1,268 @@
+//go:build !integration
+
+package storage_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ociDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeRegistry serves a single repository over the registry HTTP API, behind the bearer token flow.
+type fakeRegistry struct {
+	repository string
+	manifests  map[string][]byte
+	blobs      map[string][]byte
+	username   string
+	password   string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != f.username || pass != f.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "issued-token"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer issued-token" {
+		w.Header().Set("Www-Authenticate", fmt.Sprintf(
+			`Bearer realm="http://%s/token",service="fake-registry",scope="repository:%s:pull"`, r.Host, f.repository,
+		))
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+		return
+	}
+
+	prefix := "/v2/" + f.repository + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known"}]}`))
+		return
+	}
+
+	kind, ref, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var content []byte
+	var ok bool
+	switch kind {
+	case "manifests":
+		content, ok = f.manifests[ref]
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	case "blobs":
+		content, ok = f.blobs[ref]
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+func TestOCIClient_Clone(t *testing.T) {
+	// Arrange
+	baseLayer := buildTarGz(t, map[string]string{
+		"go-web/main.go.template": "package {{.packageName}}",
+		"go-web/Makefile":         "build:",
+	})
+	overrideLayer := buildTarGz(t, map[string]string{
+		"go-web/Makefile": "build: lint",
+	})
+	readme := []byte("# {{.projectName}}")
+	corrupt := []byte("not what the digest says")
+
+	layer := func(mediaType string, data []byte, annotations map[string]string) map[string]any {
+		return map[string]any{
+			"mediaType":   mediaType,
+			"digest":      ociDigest(data),
+			"size":        len(data),
+			"annotations": annotations,
+		}
+	}
+	manifest := func(layers ...map[string]any) []byte {
+		b, err := json.Marshal(map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"layers":        layers,
+		})
+		require.NoError(t, err)
+		return b
+	}
+
+	releaseManifest := manifest(
+		layer("application/vnd.oci.image.layer.v1.tar+gzip", baseLayer, nil),
+		layer("application/vnd.oci.image.layer.v1.tar+gzip", overrideLayer, nil),
+		layer("text/markdown", readme, map[string]string{"org.opencontainers.image.title": "go-web/README.md.template"}),
+	)
+	corruptManifest := manifest(layer("application/vnd.oci.image.layer.v1.tar+gzip", baseLayer, nil))
+
+	registry := &fakeRegistry{
+		repository: "platform/templates/go-web",
+		manifests: map[string][]byte{
+			"1.0":                      releaseManifest,
+			ociDigest(releaseManifest): releaseManifest,
+			"corrupt":                  corruptManifest,
+		},
+		blobs: map[string][]byte{
+			ociDigest(baseLayer):     baseLayer,
+			ociDigest(overrideLayer): overrideLayer,
+			ociDigest(readme):        readme,
+		},
+		username: "ci",
+		password: "registry-token",
+	}
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	auth := &types.SourceAuth{AuthAlias: "registry", UserName: "ci", Token: "registry-token"}
+
+	tests := []struct {
+		name          string
+		source        types.Source
+		corruptBlob   bool
+		expectError   bool
+		expectedFiles map[string]string
+	}{
+		{
+			name: "pulls layers in order by tag",
+			source: types.Source{
+				URL:        "oci://" + host + "/platform/templates/go-web:1.0",
+				Path:       "/go-web",
+				SourceAuth: auth,
+			},
+			expectedFiles: map[string]string{
+				"main.go.template":   "package {{.packageName}}",
+				"Makefile":           "build: lint",
+				"README.md.template": "# {{.projectName}}",
+			},
+		},
+		{
+			name: "pulls by digest",
+			source: types.Source{
+				URL:        "oci://" + host + "/platform/templates/go-web@" + ociDigest(releaseManifest),
+				SourceAuth: auth,
+			},
+			expectedFiles: map[string]string{
+				"go-web/Makefile": "build: lint",
+			},
+		},
+		{
+			name: "returns error when tag does not exist",
+			source: types.Source{
+				URL:        "oci://" + host + "/platform/templates/go-web:2.0",
+				SourceAuth: auth,
+			},
+			expectError: true,
+		},
+		{
+			name: "returns error when credentials are rejected",
+			source: types.Source{
+				URL:        "oci://" + host + "/platform/templates/go-web:1.0",
+				SourceAuth: &types.SourceAuth{AuthAlias: "registry", UserName: "ci", Token: "wrong"},
+			},
+			expectError: true,
+		},
+		{
+			name: "returns error when layer digest does not match",
+			source: types.Source{
+				URL:        "oci://" + host + "/platform/templates/go-web:corrupt",
+				SourceAuth: auth,
+			},
+			corruptBlob: true,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			if tt.corruptBlob {
+				registry.blobs[ociDigest(baseLayer)] = corrupt
+				t.Cleanup(func() { registry.blobs[ociDigest(baseLayer)] = baseLayer })
+			}
+			oc := storage.NewOCIClient()
+			oc.HTTPClient = server.Client()
+			tt.source.SourceType = types.OCISourceType
+			tt.source.Alias = "goWeb"
+			oc.SetSource(&tt.source)
+
+			// Act
+			bfs, err := oc.Clone(t.Context())
+
+			// Assert
+			if tt.expectError {
+				var ociErr *storage.OCIError
+				require.ErrorAs(t, err, &ociErr)
+				return
+			}
+			require.NoError(t, err)
+			for p, content := range tt.expectedFiles {
+				b, e := util.ReadFile(bfs, p)
+				require.NoError(t, e)
+				assert.Equal(t, content, string(b))
+			}
+		})
+	}
+}
+
+func TestOCIClient_Pinned(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		expectedRevision string
+		expectedPinned   bool
+	}{
+		{
+			name:             "pins to a digest reference",
+			url:              "oci://registry.example.com/platform/go-web@sha256:abc123",
+			expectedRevision: "sha256:abc123",
+			expectedPinned:   true,
+		},
+		{
+			name: "is not pinned to a tag",
+			url:  "oci://registry.example.com/platform/go-web:v1",
+		},
+		{
+			name: "is not pinned to the default tag",
+			url:  "oci://registry.example.com/platform/go-web",
+		},
+		{
+			name: "is not pinned to an unsupported url",
+			url:  "https://registry.example.com/platform/go-web@sha256:abc123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			oc := storage.NewOCIClient()
+			oc.SetSource(&types.Source{URL: tt.url})
+
+			// Act
+			revision, pinned := oc.Pinned()
+
+			// Assert
+			assert.Equal(t, tt.expectedPinned, pinned)
+			assert.Equal(t, tt.expectedRevision, revision)
+		})
+	}
+}
@@ -11,4 +11,29 @@ type CloneOpts struct {
 	DestinationFs   afero.Fs
 }
 
-var ErrNotImplemented error = errors.New("not implemented")
+var (
+	ErrNotImplemented error = errors.New("not implemented")
+
+	errNoSourcePath  = errors.New("no url or path set for source")
+	errNotADirectory = errors.New("not a directory")
+
+	errUnsupportedArchive = errors.New("unsupported archive format, expected .tar.gz, .tgz or .zip")
+
+	errUnsupportedArchiveURL = errors.New("unsupported archive url, expected http:// or https://")
+
+	errUnsupportedOCIURL    = errors.New("unsupported oci url, expected oci://registry/repository[:tag|@digest]")
+	errOCIIndexUnsupported  = errors.New("image indexes are not supported, reference a single manifest by digest")
+	errOCILayerUnsupported  = errors.New("unsupported layer media type without a title annotation")
+	errOCIDigestUnsupported = errors.New("unsupported digest algorithm")
+	errOCIUnauthorized      = errors.New("registry rejected the request and offered no usable auth challenge")
+
+	errGitRefNotFound    = errors.New("no branch, tag or commit with that name on the remote")
+	errUnsupportedGitURL = errors.New("unsupported git url, expected ssh://, https://, http://, git://, file:// or user@host:path")
+
+	errUnsupportedCredentialHelper = errors.New("unsupported credential helper, expected git")
+	errNoCredentials               = errors.New("no password returned")
+
+	errUnsupportedBlobURL = errors.New("unsupported blob url, expected s3://, azblob://, http:// or https://")
+	errNoBlobContainer    = errors.New("no bucket or container in blob url")
+	errNoBlobObjects      = errors.New("no objects found under prefix")
+)
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	archiveExtTarGz = ".tar.gz"
+	archiveExtTgz   = ".tgz"
+	archiveExtZip   = ".zip"
+)
+
+// IsArchive reports whether name has an extension UnpackArchive knows how to handle.
+func IsArchive(name string) bool {
+	return archiveExt(name) != ""
+}
+
+// UnpackArchive extracts a .tar.gz, .tgz or .zip archive, selected by the extension of name, into fs.
+// Entry paths are cleaned and rooted so an archive cannot write outside of fs.
+func UnpackArchive(name string, r io.Reader, fs billy.Filesystem) error {
+	var err error
+
+	switch archiveExt(name) {
+	case archiveExtTarGz, archiveExtTgz:
+		err = unpackTarGz(r, fs)
+	case archiveExtZip:
+		err = unpackZip(r, fs)
+	default:
+		err = errUnsupportedArchive
+	}
+
+	if err != nil {
+		return &ArchiveError{Name: name, OpErr: err}
+	}
+	return nil
+}
+
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{archiveExtTarGz, archiveExtTgz, archiveExtZip} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+func unpackTarGz(r io.Reader, fs billy.Filesystem) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return unpackTar(gz, fs)
+}
+
+func unpackTar(r io.Reader, fs billy.Filesystem) error {
+	tr := tar.NewReader(r)
+	links := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := archiveEntryPath(hdr.Name)
+		mode := os.FileMode(hdr.Mode).Perm() //nolint:gosec // tar modes always fit
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = fs.MkdirAll(name, mode)
+		case tar.TypeReg:
+			err = writeFile(fs, name, mode, tr)
+		case tar.TypeSymlink:
+			err = archiveSymlink(fs, links, hdr.Linkname, name)
+		default:
+			// Hard links, devices and the like have no meaning in a template source.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func unpackZip(r io.Reader, fs billy.Filesystem) error {
+	// zip needs random access, so the archive is buffered in memory.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	links := make(map[string]string)
+	for _, f := range zr.File {
+		name := archiveEntryPath(f.Name)
+		mode := f.Mode()
+
+		if mode.IsDir() {
+			if err = fs.MkdirAll(name, mode.Perm()); err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		if err = unpackZipFile(f, name, fs, links); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func unpackZipFile(f *zip.File, name string, fs billy.Filesystem, links map[string]string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if f.Mode()&os.ModeSymlink != 0 {
+		target, e := io.ReadAll(rc)
+		if e != nil {
+			return e
+		}
+		return archiveSymlink(fs, links, string(target), name)
+	}
+
+	perm := f.Mode().Perm()
+	if perm == 0 {
+		// Archives created on Windows carry no unix permissions.
+		perm = 0644
+	}
+
+	return writeFile(fs, name, perm, rc)
+}
+
+/*
+archiveSymlink creates a symlink, refusing targets that would resolve outside of the archive through
+the links it already holds, see symlinkInRoot, and records it in links.
+*/
+func archiveSymlink(fs billy.Filesystem, links map[string]string, target string, name string) error {
+	if err := symlinkInRoot(links, name, target); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(path.Dir(name), 0755); err != nil { //nolint:mnd
+		return err
+	}
+	if err := fs.Symlink(target, name); err != nil {
+		return err
+	}
+	links[cleanSourcePath(name)] = target
+	return nil
+}
+
+// archiveEntryPath roots and cleans an archive entry name, discarding any leading "..".
+func archiveEntryPath(name string) string {
+	return path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+}
@@ -1,11 +1,17 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/OneFineDev/tmpltr/internal/types"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/spf13/afero"
@@ -14,42 +20,249 @@ import (
 type SafeFs struct {
 	mu sync.Mutex
 	Fs afero.Fs
+
+	// ConflictPolicy resolves files that more than one source writes, see CopySourceSafe
+	ConflictPolicy ConflictPolicy
+
+	writers   map[string][]string
+	conflicts map[string]*Conflict
+
+	// links holds the symlinks created so far, target by destination path
+	links map[string]string
 }
 
 // CopyFileSystemSafe recursively walks a directory and copies its contents.
 func (sf *SafeFs) CopyFileSystemSafe(fs billy.Filesystem, root string, dest string) error {
+	return sf.CopySourceSafe("", fs, root, dest)
+}
+
+/*
+CopySourceSafe recursively walks a directory of the named source and copies its contents. A file an
+earlier source already wrote is resolved by the strategy the ConflictPolicy gives it:
+  - error fails the copy with a FileConflictError.
+  - first-wins keeps the earlier copy.
+  - last-wins replaces it.
+  - skip leaves the file out of the destination, whichever sources hold it.
+  - merge merges the copies with the handler for the file's type, see ConflictPolicy.merger. Symlinks
+    can't be merged and are replaced as with last-wins.
+
+A symlink whose target resolves outside of dest, through the links already copied there, is refused
+with an UnsafeSymlinkError, see symlinkInRoot. A file replacing a link replaces the link itself rather
+than being written through it.
+
+Every file resolved this way is listed by Conflicts.
+*/
+func (sf *SafeFs) CopySourceSafe(source string, fs billy.Filesystem, root string, dest string) error {
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
+	return sf.copyTree(source, fs, root, dest)
+}
+
+// Conflicts returns the files written by more than one source so far, ordered by path.
+func (sf *SafeFs) Conflicts() []Conflict {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	conflicts := make([]Conflict, 0, len(sf.conflicts))
+	for _, c := range sf.conflicts {
+		conflicts = append(conflicts, Conflict{Path: c.Path, Sources: slices.Clone(c.Sources), Strategy: c.Strategy})
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+
+	return conflicts
+}
+
+/*
+claim records source as a writer of dest, the copy of name. When an earlier source wrote dest too it
+returns the strategy that resolves the conflict, or a FileConflictError for the error strategy.
+*/
+func (sf *SafeFs) claim(source, name, dest string) (types.ConflictStrategy, error) {
+	if sf.writers == nil {
+		sf.writers = make(map[string][]string)
+		sf.conflicts = make(map[string]*Conflict)
+	}
+
+	earlier := sf.writers[dest]
+	writers := append(slices.Clone(earlier), source)
+	sf.writers[dest] = writers
+	if len(earlier) == 0 {
+		return "", nil
+	}
+
+	strategy := sf.ConflictPolicy.strategy(name)
+	sf.conflicts[dest] = &Conflict{Path: dest, Sources: writers, Strategy: strategy}
+	if strategy == types.ErrorConflictStrategy {
+		return strategy, &FileConflictError{Path: dest, Sources: writers}
+	}
+
+	return strategy, nil
+}
+
+// copySourceFile copies the file src of source to dest, resolving it with claim.
+func (sf *SafeFs) copySourceFile(source string, fs billy.Filesystem, src, dest string, perm os.FileMode) error {
+	strategy, err := sf.claim(source, src, dest)
+	if err != nil {
+		return err
+	}
+
+	switch strategy {
+	case types.FirstWinsConflictStrategy:
+		return nil
+	case types.SkipConflictStrategy:
+		return removeIfExists(sf.Fs, dest)
+	case types.MergeConflictStrategy:
+		return sf.mergeFile(fs, src, dest, perm)
+	default:
+		if _, ok := sf.links[dest]; ok {
+			if err = removeIfExists(sf.Fs, dest); err != nil {
+				return err
+			}
+			delete(sf.links, dest)
+		}
+		return copyFile(fs, src, dest, perm, sf.Fs)
+	}
+}
+
+// mergeFile merges src into the copy of it already at dest.
+func (sf *SafeFs) mergeFile(fs billy.Filesystem, src, dest string, perm os.FileMode) error {
+	existing, err := afero.ReadFile(sf.Fs, dest)
+	if err != nil {
+		return &MergeError{Path: dest, OpErr: err}
+	}
+
+	incoming, err := util.ReadFile(fs, src)
+	if err != nil {
+		return &MergeError{Path: dest, OpErr: err}
+	}
+
+	merged, err := sf.ConflictPolicy.merger(src)(src, existing, incoming)
+	if err != nil {
+		return &MergeError{Path: dest, OpErr: err}
+	}
+
+	if err = afero.WriteFile(sf.Fs, dest, merged, perm); err != nil {
+		return err
+	}
+	return sf.Fs.Chmod(dest, perm)
+}
+
+func removeIfExists(fs afero.Fs, name string) error {
+	if err := fs.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// copyTree walks root in fs and copies it into dest, preserving file modes and symlinks.
+func (sf *SafeFs) copyTree(source string, fs billy.Filesystem, root string, dest string) error {
 	return util.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		destPath := filepath.Join(dest, path)
 
-		if info.IsDir() {
+		switch {
+		case info.IsDir():
 			e := sf.Fs.MkdirAll(destPath, 0775) //nolint:mnd
 			if e != nil {
 				return e
 			}
-		} else {
-			e := copyFile(fs, path, destPath, sf.Fs)
+		case info.Mode()&os.ModeSymlink != 0:
+			e := sf.copySymlink(source, fs, path, dest, destPath)
 			if e != nil {
 				return e
 			}
+		default:
+			e := sf.copySourceFile(source, fs, path, destPath, info.Mode().Perm())
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+// copySymlink recreates a symlink in the destination when it supports links, otherwise the
+// link target's content is copied in its place. Links that resolve outside of root are refused.
+func (sf *SafeFs) copySymlink(source string, fs billy.Filesystem, src, root, dest string) error {
+	target, err := fs.Readlink(src)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, dest)
+	if err != nil {
+		return err
+	}
+	if err = symlinkInRoot(sf.linksUnder(root), rel, target); err != nil {
+		return err
+	}
+
+	strategy, err := sf.claim(source, src, dest)
+	if err != nil {
+		return err
+	}
+	switch strategy {
+	case types.FirstWinsConflictStrategy:
+		return nil
+	case types.SkipConflictStrategy:
+		return removeIfExists(sf.Fs, dest)
+	}
+
+	if linker, ok := sf.Fs.(afero.Linker); ok {
+		// A previous source may already have written this path.
+		if e := removeIfExists(sf.Fs, dest); e != nil {
+			return e
+		}
+		if e := linker.SymlinkIfPossible(target, dest); e != nil {
+			return e
 		}
+		if sf.links == nil {
+			sf.links = make(map[string]string)
+		}
+		sf.links[dest] = target
 		return nil
+	}
+
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return copyFile(fs, src, dest, info.Mode().Perm(), sf.Fs)
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(src), target)
+	}
+
+	// Walk the link target, but write it out under the link's own name.
+	return util.Walk(fs, target, func(path string, fi os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		rel, e := filepath.Rel(target, path)
+		if e != nil {
+			return e
+		}
+		destPath := filepath.Join(dest, rel)
+		if fi.IsDir() {
+			return sf.Fs.MkdirAll(destPath, 0775) //nolint:mnd
+		}
+		return copyFile(fs, path, destPath, fi.Mode().Perm(), sf.Fs)
 	})
 }
 
-// copyFile copies a file.
-func copyFile(fs billy.Filesystem, src, dest string, localFs afero.Fs) error {
+// copyFile copies a file, applying perm to the destination.
+func copyFile(fs billy.Filesystem, src, dest string, perm os.FileMode, localFs afero.Fs) error {
 	sourceFile, err := fs.Open(src)
 	if err != nil {
 		return err
 	}
 	defer sourceFile.Close()
 
-	destFile, err := localFs.Create(dest)
+	destFile, err := localFs.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
@@ -60,5 +273,121 @@ func copyFile(fs billy.Filesystem, src, dest string, localFs afero.Fs) error {
 		return err
 	}
 
+	// OpenFile only applies perm on creation, so make sure an overwritten file picks it up too.
+	err = localFs.Chmod(dest, perm)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeFile writes the content of r to name in fs, creating any missing parent directories.
+func writeFile(fs billy.Filesystem, name string, perm os.FileMode, r io.Reader) error {
+	if err := fs.MkdirAll(path.Dir(name), 0755); err != nil { //nolint:mnd
+		return err
+	}
+
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// cleanSourcePath returns a source Path relative to the source root, or "" for the root itself.
+func cleanSourcePath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+/*
+ChrootSourcePath reroots fs at the source's Path, if one is set. Clients that can only fetch a source
+whole, such as BlobClient, ArchiveClient and OCIClient, read Source.Path out of what they fetched with it.
+*/
+func ChrootSourcePath(fs billy.Filesystem, p string) (billy.Filesystem, error) {
+	if p == "" || p == "/" {
+		return fs, nil
+	}
+
+	if _, err := fs.Stat(p); err != nil {
+		return nil, &SourcePathError{SourcePath: p, OpErr: err}
+	}
+
+	return fs.Chroot(p)
+}
+
+// linksUnder returns the links created under root, target by path relative to it.
+func (sf *SafeFs) linksUnder(root string) map[string]string {
+	links := make(map[string]string)
+	for dest, target := range sf.links {
+		if rel, err := filepath.Rel(root, dest); err == nil && filepath.IsLocal(rel) {
+			links[filepath.ToSlash(rel)] = target
+		}
+	}
+	return links
+}
+
+// maxSymlinkHops bounds the links symlinkInRoot follows, as the OS does, so a cycle of links ends.
+const maxSymlinkHops = 40
+
+/*
+symlinkInRoot returns an UnsafeSymlinkError unless the link name, pointing at target, resolves within
+the root it is created under. links holds the links already under the root, target by path relative
+to it, and every link met along the way, the directories of name included, is followed rather than
+cleaned lexically, so a chain of links can't leave the root either. Absolute targets always leave it.
+*/
+func symlinkInRoot(links map[string]string, name, target string) error {
+	name = cleanSourcePath(filepath.ToSlash(name))
+	hops := 0
+
+	dir, ok := resolveInRoot(links, nil, path.Dir(name), &hops)
+	if ok {
+		_, ok = resolveInRoot(links, dir, target, &hops)
+	}
+	if !ok {
+		return &UnsafeSymlinkError{Path: name, Target: target}
+	}
 	return nil
 }
+
+// resolveInRoot resolves rel from the directory dir, following links, and reports whether it stays within the root.
+func resolveInRoot(links map[string]string, dir []string, rel string, hops *int) ([]string, bool) {
+	rel = strings.ReplaceAll(rel, "\\", "/")
+	if path.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return nil, false
+	}
+
+	resolved := slices.Clone(dir)
+	for _, part := range strings.Split(rel, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return nil, false
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		resolved = append(resolved, part)
+		target, isLink := links[strings.Join(resolved, "/")]
+		if !isLink {
+			continue
+		}
+
+		*hops++
+		if *hops > maxSymlinkHops {
+			return nil, false
+		}
+		var ok bool
+		if resolved, ok = resolveInRoot(links, resolved[:len(resolved)-1], target, hops); !ok {
+			return nil, false
+		}
+	}
+
+	return resolved, true
+}
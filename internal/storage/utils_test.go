@@ -4,12 +4,16 @@ package storage_test
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/OneFineDev/tmpltr/internal/storage"
+	"github.com/OneFineDev/tmpltr/internal/types"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +53,55 @@ func TestSafeFs_CopyFileSystemSafe(t *testing.T) {
 				assert.Equal(t, "content", string(content))
 			},
 		},
+		{
+			name: "preserves file modes",
+			setupSourceFs: func() billy.Filesystem {
+				fs := memfs.New()
+				fs.MkdirAll("/src", 0755)
+				file, _ := fs.OpenFile("/src/init.sh", os.O_CREATE|os.O_WRONLY, 0755)
+				file.Write([]byte("#!/bin/sh"))
+				file.Close()
+				return fs
+			},
+			setupDestFs: func() afero.Fs { //nolint:gocritic // For sig consistency
+				return afero.NewMemMapFs()
+			},
+			root:          "/src",
+			dest:          "/dest",
+			expectedError: nil,
+			verify: func(t *testing.T, destFs afero.Fs) {
+				info, err := destFs.Stat("/dest/src/init.sh")
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+			},
+		},
+		{
+			name: "copies symlink targets when destination cannot link",
+			setupSourceFs: func() billy.Filesystem {
+				fs := memfs.New()
+				fs.MkdirAll("/src/dir", 0755)
+				file, _ := fs.Create("/src/dir/file.txt")
+				file.Write([]byte("content"))
+				file.Close()
+				fs.Symlink("dir/file.txt", "/src/file-link.txt")
+				fs.Symlink("dir", "/src/dir-link")
+				return fs
+			},
+			setupDestFs: func() afero.Fs { //nolint:gocritic // For sig consistency
+				return afero.NewMemMapFs()
+			},
+			root:          "/src",
+			dest:          "/dest",
+			expectedError: nil,
+			verify: func(t *testing.T, destFs afero.Fs) {
+				content, err := afero.ReadFile(destFs, "/dest/src/file-link.txt")
+				require.NoError(t, err)
+				assert.Equal(t, "content", string(content))
+				content, err = afero.ReadFile(destFs, "/dest/src/dir-link/file.txt")
+				require.NoError(t, err)
+				assert.Equal(t, "content", string(content))
+			},
+		},
 		{
 			name: "returns error when source directory does not exist",
 			setupSourceFs: func() billy.Filesystem { //nolint:gocritic // For sig consistency
@@ -85,3 +138,223 @@ func TestSafeFs_CopyFileSystemSafe(t *testing.T) {
 		})
 	}
 }
+
+func TestSafeFs_CopySourceSafe_Symlinks(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name         string
+		earlierLinks map[string]string
+		links        map[string]string
+		expectErr    bool
+	}{
+		{
+			name:  "link within the destination",
+			links: map[string]string{"dir/link": "../file.txt"},
+		},
+		{
+			name:      "link out of the destination",
+			links:     map[string]string{"link": "../outside"},
+			expectErr: true,
+		},
+		{
+			name:         "link out of the destination through an earlier source's link",
+			earlierLinks: map[string]string{"here": "."},
+			links:        map[string]string{"up": "here/.."},
+			expectErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			dest := filepath.Join(t.TempDir(), "project")
+			safeFs := &storage.SafeFs{Fs: afero.NewOsFs()}
+			source := func(links map[string]string) billy.Filesystem {
+				fs := memfs.New()
+				require.NoError(t, util.WriteFile(fs, "file.txt", []byte("content"), 0644))
+				for name, target := range links {
+					require.NoError(t, fs.MkdirAll(filepath.Dir(name), 0755))
+					require.NoError(t, fs.Symlink(target, name))
+				}
+				return fs
+			}
+			require.NoError(t, safeFs.CopySourceSafe("earlier", source(tt.earlierLinks), "/", dest))
+
+			// Act
+			err := safeFs.CopySourceSafe("later", source(tt.links), "/", dest)
+
+			// Assert
+			if !tt.expectErr {
+				require.NoError(t, err)
+				return
+			}
+			var linkErr *storage.UnsafeSymlinkError
+			require.ErrorAs(t, err, &linkErr)
+		})
+	}
+}
+
+func TestSafeFs_CopySourceSafe_Conflicts(t *testing.T) {
+	// common and goTooling both hold .gitignore and Makefile, and are copied in that order.
+	sources := []struct {
+		alias string
+		files map[string]string
+	}{
+		{"common", map[string]string{".gitignore": "*.log\n", "Makefile": "common", "ci/build.yml": "common"}},
+		{"goTooling", map[string]string{".gitignore": "bin/", "Makefile": "goTooling", "ci/build.yml": "goTooling"}},
+		{"project", map[string]string{"main.go": "package main"}},
+	}
+
+	// Arrange
+	tests := []struct {
+		name              string
+		policy            storage.ConflictPolicy
+		expectedFiles     map[string]string
+		expectedMissing   []string
+		expectedConflicts []storage.Conflict
+		expectedErr       *storage.FileConflictError
+	}{
+		{
+			name:   "later sources win by default",
+			policy: storage.ConflictPolicy{},
+			expectedFiles: map[string]string{
+				"/out/.gitignore":   "bin/",
+				"/out/Makefile":     "goTooling",
+				"/out/ci/build.yml": "goTooling",
+				"/out/main.go":      "package main",
+			},
+			expectedConflicts: []storage.Conflict{
+				{Path: "/out/.gitignore", Sources: []string{"common", "goTooling"}, Strategy: types.LastWinsConflictStrategy},
+				{Path: "/out/Makefile", Sources: []string{"common", "goTooling"}, Strategy: types.LastWinsConflictStrategy},
+				{Path: "/out/ci/build.yml", Sources: []string{"common", "goTooling"}, Strategy: types.LastWinsConflictStrategy},
+			},
+		},
+		{
+			name:   "first source wins",
+			policy: storage.ConflictPolicy{Strategy: types.FirstWinsConflictStrategy},
+			expectedFiles: map[string]string{
+				"/out/.gitignore": "*.log\n",
+				"/out/Makefile":   "common",
+			},
+			expectedConflicts: []storage.Conflict{
+				{Path: "/out/.gitignore", Sources: []string{"common", "goTooling"}, Strategy: types.FirstWinsConflictStrategy},
+				{Path: "/out/Makefile", Sources: []string{"common", "goTooling"}, Strategy: types.FirstWinsConflictStrategy},
+				{Path: "/out/ci/build.yml", Sources: []string{"common", "goTooling"}, Strategy: types.FirstWinsConflictStrategy},
+			},
+		},
+		{
+			name: "globs pick the strategy before the default",
+			policy: storage.ConflictPolicy{
+				Strategy: types.ErrorConflictStrategy,
+				Rules: []types.ConflictRule{
+					{Glob: ".gitignore", Strategy: types.MergeConflictStrategy},
+					{Glob: "Makefile", Strategy: types.SkipConflictStrategy},
+					{Glob: "ci/*.yml", Strategy: types.FirstWinsConflictStrategy},
+				},
+			},
+			expectedFiles: map[string]string{
+				"/out/.gitignore":   "*.log\nbin/\n",
+				"/out/ci/build.yml": "common",
+				"/out/main.go":      "package main",
+			},
+			expectedMissing: []string{"/out/Makefile"},
+			expectedConflicts: []storage.Conflict{
+				{Path: "/out/.gitignore", Sources: []string{"common", "goTooling"}, Strategy: types.MergeConflictStrategy},
+				{Path: "/out/Makefile", Sources: []string{"common", "goTooling"}, Strategy: types.SkipConflictStrategy},
+				{Path: "/out/ci/build.yml", Sources: []string{"common", "goTooling"}, Strategy: types.FirstWinsConflictStrategy},
+			},
+		},
+		{
+			name: "error strategy fails the copy",
+			policy: storage.ConflictPolicy{
+				Strategy: types.ErrorConflictStrategy,
+				Rules:    []types.ConflictRule{{Glob: ".gitignore", Strategy: types.MergeConflictStrategy}},
+			},
+			expectedErr: &storage.FileConflictError{Path: "/out/Makefile", Sources: []string{"common", "goTooling"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			safeFs := &storage.SafeFs{Fs: afero.NewMemMapFs(), ConflictPolicy: tt.policy}
+
+			// Act
+			var err error
+			for _, source := range sources {
+				fs := memfs.New()
+				for name, content := range source.files {
+					require.NoError(t, util.WriteFile(fs, name, []byte(content), 0644))
+				}
+				if err = safeFs.CopySourceSafe(source.alias, fs, "/", "/out"); err != nil {
+					break
+				}
+			}
+
+			// Assert
+			if tt.expectedErr != nil {
+				var conflictErr *storage.FileConflictError
+				require.ErrorAs(t, err, &conflictErr)
+				assert.Equal(t, tt.expectedErr, conflictErr)
+				return
+			}
+			require.NoError(t, err)
+			for name, expected := range tt.expectedFiles {
+				content, e := afero.ReadFile(safeFs.Fs, name)
+				require.NoError(t, e)
+				assert.Equal(t, expected, string(content), name)
+			}
+			for _, name := range tt.expectedMissing {
+				_, e := safeFs.Fs.Stat(name)
+				require.ErrorIs(t, e, os.ErrNotExist)
+			}
+			assert.Equal(t, tt.expectedConflicts, safeFs.Conflicts())
+		})
+	}
+}
+
+func TestConflictPolicy_Validate(t *testing.T) {
+	// Arrange
+	tests := []struct {
+		name          string
+		policy        storage.ConflictPolicy
+		expectedError string
+	}{
+		{
+			name: "valid policy",
+			policy: storage.ConflictPolicy{
+				Strategy: types.SkipConflictStrategy,
+				Rules:    []types.ConflictRule{{Glob: "*.json", Strategy: types.MergeConflictStrategy}},
+			},
+		},
+		{
+			name:          "unknown default strategy",
+			policy:        storage.ConflictPolicy{Strategy: "newest-wins"},
+			expectedError: `unknown conflict strategy "newest-wins"`,
+		},
+		{
+			name:          "rule without a strategy",
+			policy:        storage.ConflictPolicy{Rules: []types.ConflictRule{{Glob: "Makefile"}}},
+			expectedError: `unknown conflict strategy "" for glob "Makefile"`,
+		},
+		{
+			name:          "malformed glob",
+			policy:        storage.ConflictPolicy{Rules: []types.ConflictRule{{Glob: "[", Strategy: types.SkipConflictStrategy}}},
+			expectedError: `invalid conflict glob "["`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := tt.policy.Validate()
+
+			// Assert
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
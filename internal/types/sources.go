@@ -12,53 +12,166 @@ type SourceCloner interface {
 	// GetSource() Source
 }
 
+/*
+SourceResolver is implemented by SourceCloners that can resolve their source's ref to an immutable
+revision without fetching its content, which lets cached content be reused while it is current.
+*/
+type SourceResolver interface {
+	Resolve(ctx context.Context) (string, error)
+}
+
+/*
+SourcePinner is implemented by SourceCloners whose source can be pinned to content that never changes,
+such as an archive checksum or an OCI digest. Pinned returns that revision, ok is false for a source
+that isn't pinned. Cached content of a pinned source is reused without contacting the remote at all.
+*/
+type SourcePinner interface {
+	Pinned() (revision string, ok bool)
+}
+
+/*
+SourceFetcher is implemented by SourceCloners that can fetch their source once and read any number of
+paths out of that one fetch, which lets sources that only differ by path share it.
+*/
+type SourceFetcher interface {
+	Fetch(ctx context.Context) (SourceSnapshot, error)
+}
+
+/*
+CredentialPrompter is implemented by SourceCloners that may ask for credentials interactively, which
+lets them be asked for before the source is fetched, outside any timeout on the fetch.
+*/
+type CredentialPrompter interface {
+	PromptCredentials() error
+}
+
+/*
+SourceSnapshot is the content of a source as fetched by a SourceFetcher. It must be closed when done.
+Revision is the immutable revision it holds, which may be newer than the one resolved before fetching.
+*/
+type SourceSnapshot interface {
+	Path(p string) (billy.Filesystem, error)
+	Revision() string
+	Close() error
+}
+
 type SourceType string
 
+type BlobProvider string
+
+type CredentialHelper string
+
+type ConflictStrategy string
+
+type MergeFormat string
+
 type (
-	GitSource  Source
-	FileSource Source
-	BlobSource Source
+	GitSource     Source
+	FileSource    Source
+	BlobSource    Source
+	ArchiveSource Source
+	OCISource     Source
+)
+
+const (
+	GitSourceType     SourceType = "git"
+	FileSourceType    SourceType = "file"
+	BlobSourceType    SourceType = "blob"
+	ArchiveSourceType SourceType = "archive"
+	OCISourceType     SourceType = "oci"
 )
 
 const (
-	GitSourceType  SourceType = "git"
-	FileSourceType SourceType = "file"
-	BlobSourceType SourceType = "blob"
+	S3BlobProvider    BlobProvider = "s3"
+	AzureBlobProvider BlobProvider = "azure"
+)
+
+const (
+	GitCredentialHelper CredentialHelper = "git"
+)
+
+const (
+	ErrorConflictStrategy     ConflictStrategy = "error"
+	FirstWinsConflictStrategy ConflictStrategy = "first-wins"
+	LastWinsConflictStrategy  ConflictStrategy = "last-wins"
+	SkipConflictStrategy      ConflictStrategy = "skip"
+	MergeConflictStrategy     ConflictStrategy = "merge"
+)
+
+const (
+	JSONMergeFormat   MergeFormat = "json"
+	YAMLMergeFormat   MergeFormat = "yaml"
+	LinesMergeFormat  MergeFormat = "lines"
+	ConcatMergeFormat MergeFormat = "concat"
 )
 
 /*
-Source represents the source of a set of template files that will be rendered together.
+Source represents the source of a set of template files that will be rendered together. Target is
+the subdirectory of the project the source is rendered at, the project root when empty. It's a
+template, so can hold values such as {{.projectName}}.
 */
 type Source struct {
-	SourceType      `             json:"source_type"       yaml:"sourceType"`
-	URL             string `json:"url"               yaml:"url"`
-	Alias           string `json:"alias"             yaml:"alias"`
-	Path            string `json:"path"              yaml:"path"`
-	*SourceAuth     `             json:"-"                 yaml:",inline"`
-	SourceAuthAlias string `json:"source_auth_alias" yaml:"sourceAuthAlias"`
-	Client          SourceCloner
+	SourceType            `             json:"source_type"              yaml:"sourceType"`
+	URL                   string       `json:"url"                      yaml:"url"`
+	Alias                 string       `json:"alias"                    yaml:"alias"`
+	Path                  string       `json:"path"                     yaml:"path"`
+	Ref                   string       `json:"ref"                      yaml:"ref"`
+	Region                string       `json:"region"                   yaml:"region"`
+	Provider              BlobProvider `json:"provider"                 yaml:"provider"`
+	SHA256                string       `json:"sha256"                   yaml:"sha256"`
+	KnownHostsPath        string       `json:"known_hosts_path"         yaml:"knownHostsPath"`
+	InsecureIgnoreHostKey bool         `json:"insecure_ignore_host_key" yaml:"insecureIgnoreHostKey"`
+	Target                string       `json:"target"                   yaml:"target"`
+	*SourceAuth           `             json:"-"                        yaml:",inline"`
+	SourceAuthAlias       string `json:"source_auth_alias"        yaml:"sourceAuthAlias"`
+	Client                SourceCloner
 }
 
 /*
-SourceAuth represents the authentication details for the source in which it is embedded.
+SourceAuth represents the authentication details for the source in which it is embedded. Untrusted is
+set on auths read from a catalog, which can't be set from the file itself.
 */
 type SourceAuth struct {
-	AuthAlias string `json:"auth_alias"   yaml:"authAlias"`
-	UserName  string `json:"username"     yaml:"userName"`
-	Pat       string `json:"pat"          yaml:"pat"`
-	SSHKey    string `json:"ssh_key_path" yaml:"sshKeyPath"`
-	Key       string `json:"key"          yaml:"key"`
-	Token     string `json:"token"        yaml:"token"`
+	AuthAlias        string           `json:"auth_alias"         yaml:"authAlias"`
+	UserName         string           `json:"username"           yaml:"userName"`
+	Pat              string           `json:"pat"                yaml:"pat"`
+	SSHKey           string           `json:"ssh_key_path"       yaml:"sshKeyPath"`
+	SSHKeyPassphrase string           `json:"ssh_key_passphrase" yaml:"sshKeyPassphrase"`
+	Key              string           `json:"key"                yaml:"key"`
+	Token            string           `json:"token"              yaml:"token"`
+	CredentialHelper CredentialHelper `json:"credential_helper"  yaml:"credentialHelper"`
+	Untrusted        bool             `json:"-"                  yaml:"-"`
 }
 
 /*
 SourceSet represents a collection of sources that collectively represent a project.
 When a SourceSet in specified in a command, all Sources in that set will be fetched and rendered.
+Sources are layered in the order they're listed. Where two sources hold the same file, OnConflict,
+or the first of Conflicts whose glob matches the file, decides which copy is rendered. By default
+the later source's copy is. Targets sets the Target of the set's sources by alias, in place of their own.
+Values are default template values, keyed by dotted path, below a values file or interactive input.
+A set that Extends other sets gets their sources ahead of its own, and their Values, Targets and
+Conflicts below its own.
 */
 type SourceSet struct {
-	Alias   string            `json:"alias"   yaml:"alias"`
-	Sources []string          `json:"sources" yaml:"sources"`
-	Values  map[string]string `json:"values"  yaml:"values"`
+	Alias      string            `json:"alias"       yaml:"alias"`
+	Extends    []string          `json:"extends"     yaml:"extends"`
+	Sources    []string          `json:"sources"     yaml:"sources"`
+	Values     map[string]string `json:"values"      yaml:"values"`
+	OnConflict ConflictStrategy  `json:"on_conflict" yaml:"onConflict"`
+	Conflicts  []ConflictRule    `json:"conflicts"   yaml:"conflicts"`
+	Targets    map[string]string `json:"targets"     yaml:"targets"`
+}
+
+/*
+ConflictRule sets the ConflictStrategy for the files matching Glob. A glob without a / matches file
+names in any directory, otherwise it matches paths from the source root. Merge picks how the merge
+strategy merges them, by default it goes by file type.
+*/
+type ConflictRule struct {
+	Glob     string           `json:"glob"     yaml:"glob"`
+	Strategy ConflictStrategy `json:"strategy" yaml:"strategy"`
+	Merge    MergeFormat      `json:"merge"    yaml:"merge"`
 }
 
 type Sources []Source
@@ -67,7 +180,12 @@ type SourceAuths []SourceAuth
 
 func (t SourceConfig) Yamafiable() {}
 
+/*
+SourceConfig is the content of a source config file. When Namespace is set, the aliases the file
+defines are prefixed with it, e.g. platform/goWeb, so they can't collide with those of other files.
+*/
 type SourceConfig struct {
+	Namespace   string      `json:"namespace"    yaml:"namespace"`
 	SourceAuths SourceAuths `json:"source_auths" yaml:"sourceAuths"`
 	Sources     Sources     `json:"sources"      yaml:"sources"`
 	SourceSets  SourceSets  `json:"source_sets"  yaml:"sourceSets"`
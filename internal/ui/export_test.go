@@ -0,0 +1,101 @@
+//go:build !integration
+
+package ui
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestPassphrasePrompter_Prompt(t *testing.T) {
+	// Arrange
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(key, "", []byte("correct horse"))
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_encrypted")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	tests := []struct {
+		name            string
+		cached          map[string]string
+		answers         []string
+		expected        string
+		expectedAsked   int
+		expectedCached  map[string]string
+		expectedErrText string
+	}{
+		{
+			name:           "Correct passphrase is cached",
+			answers:        []string{"correct horse"},
+			expected:       "correct horse",
+			expectedAsked:  1,
+			expectedCached: map[string]string{keyPath: "correct horse"},
+		},
+		{
+			name:           "Incorrect passphrase is asked for again",
+			answers:        []string{"wrong", "correct horse"},
+			expected:       "correct horse",
+			expectedAsked:  2,
+			expectedCached: map[string]string{keyPath: "correct horse"},
+		},
+		{
+			name:            "Incorrect passphrase isn't cached",
+			answers:         []string{"wrong", "wrong", "wrong"},
+			expectedAsked:   3,
+			expectedCached:  map[string]string{},
+			expectedErrText: "incorrect passphrase for " + keyPath,
+		},
+		{
+			name:           "Cached passphrase is reused",
+			cached:         map[string]string{keyPath: "correct horse"},
+			expected:       "correct horse",
+			expectedCached: map[string]string{keyPath: "correct horse"},
+		},
+		{
+			name:           "Cached passphrase that no longer decrypts the key is cleared",
+			cached:         map[string]string{keyPath: "stale"},
+			answers:        []string{"correct horse"},
+			expected:       "correct horse",
+			expectedAsked:  1,
+			expectedCached: map[string]string{keyPath: "correct horse"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			p := NewPassphrasePrompter()
+			for k, v := range tt.cached {
+				p.passphrases[k] = v
+			}
+			asked := 0
+			p.ask = func(string) (string, error) {
+				answer := tt.answers[asked]
+				asked++
+				return answer, nil
+			}
+
+			// Act
+			passphrase, err := p.Prompt(keyPath)
+
+			// Assert
+			if tt.expectedErrText != "" {
+				require.ErrorContains(t, err, tt.expectedErrText)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, passphrase)
+			assert.Equal(t, tt.expectedAsked, asked)
+			assert.Equal(t, tt.expectedCached, p.passphrases)
+		})
+	}
+}
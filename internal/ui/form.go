@@ -41,7 +41,12 @@ func Flatten(prefix string, src map[string]interface{}, dest map[string]*string)
 		// 		dest[prefix+k+"."+strconv.Itoa(i)] = child[i]
 		// 	}
 		default:
-			dest[prefix+k] = new(string)
+			// Values already set, such as source set defaults, are the field's starting value.
+			value := new(string)
+			if s, ok := child.(string); ok {
+				*value = s
+			}
+			dest[prefix+k] = value
 		}
 	}
 }
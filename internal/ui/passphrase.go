@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/huh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// maxPassphraseAttempts is the number of times an incorrect passphrase is asked for before giving up.
+const maxPassphraseAttempts = 3
+
+/*
+PassphrasePrompter asks for SSH key passphrases on the terminal, once per key. Sources are cloned
+concurrently, so prompts are serialised and a passphrase entered for one source is reused by every
+other source using the same key.
+*/
+type PassphrasePrompter struct {
+	mu          sync.Mutex
+	passphrases map[string]string
+
+	// ask asks for a passphrase under title
+	ask func(title string) (string, error)
+}
+
+func NewPassphrasePrompter() *PassphrasePrompter {
+	return &PassphrasePrompter{
+		passphrases: make(map[string]string),
+		ask:         askPassphrase,
+	}
+}
+
+/*
+Prompt returns the passphrase for the key at keyPath, asking for it the first time. A passphrase is only
+kept once it decrypts the key, an incorrect one is asked for again up to maxPassphraseAttempts times, and
+a kept one that no longer decrypts the key, because the key was replaced, is asked for again.
+*/
+func (p *PassphrasePrompter) Prompt(keyPath string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase, ok := p.passphrases[keyPath]; ok {
+		if _, err = gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase)); err == nil {
+			return passphrase, nil
+		}
+		delete(p.passphrases, keyPath)
+	}
+
+	title := fmt.Sprintf("Enter passphrase for %s", keyPath)
+	for attempt := 1; ; attempt++ {
+		passphrase, err := p.ask(title)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		if err == nil {
+			p.passphrases[keyPath] = passphrase
+			return passphrase, nil
+		}
+		if attempt == maxPassphraseAttempts {
+			return "", fmt.Errorf("incorrect passphrase for %s: %w", keyPath, err)
+		}
+
+		title = fmt.Sprintf("Incorrect passphrase, enter passphrase for %s", keyPath)
+	}
+}
+
+func askPassphrase(title string) (string, error) {
+	var passphrase string
+	err := huh.NewInput().
+		Title(title).
+		EchoMode(huh.EchoModePassword).
+		Value(&passphrase).
+		Run()
+	return passphrase, err
+}